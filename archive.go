@@ -0,0 +1,123 @@
+package hack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// archiveFormat identifies the format of archive files so that archives written by an incompatible
+// version of the assembler are rejected.
+const archiveFormat = "hack-archive/1"
+
+// Archive is a static library bundling several objects. When linking only the objects that export
+// a label referenced by the program are linked. Objects that are not referenced do not take up any
+// ROM.
+type Archive struct {
+	// Name is the name of the archive file used in errors. It is not part of the archive file.
+	Name    string          `json:"-"`
+	Format  string          `json:"format"`
+	Members []archiveMember `json:"members"`
+}
+
+// archiveMember is an object in an archive.
+type archiveMember struct {
+	Name   string  `json:"name"`
+	Object *Object `json:"object"`
+}
+
+// WriteArchive writes an archive of objects to w. Objects are stored under their name which needs to
+// be unique.
+func WriteArchive(w io.Writer, objects []*Object) error {
+	ar := Archive{Format: archiveFormat}
+	names := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		if names[o.Name] {
+			return fmt.Errorf("failed to write archive: object %q is added more than once", o.Name)
+		}
+		names[o.Name] = true
+		ar.Members = append(ar.Members, archiveMember{Name: o.Name, Object: o})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(ar)
+}
+
+// ReadArchive reads an archive written by WriteArchive. The name of the archive is used in errors.
+// Objects in the archive are named like ARCHIVE(OBJECT).
+func ReadArchive(r io.Reader, name string) (*Archive, error) {
+	var ar Archive
+	if err := json.NewDecoder(r).Decode(&ar); err != nil {
+		return nil, fmt.Errorf("failed to read archive %q: %v", name, err)
+	}
+	if ar.Format != archiveFormat {
+		return nil, fmt.Errorf("failed to read archive %q: expected format %q instead got %q", name, archiveFormat, ar.Format)
+	}
+	for _, m := range ar.Members {
+		if m.Object == nil {
+			return nil, fmt.Errorf("failed to read archive %q: member %q has no object", name, m.Name)
+		}
+		m.Object.Name = name + "(" + m.Name + ")"
+		if err := m.Object.check(); err != nil {
+			return nil, err
+		}
+	}
+	ar.Name = name
+	return &ar, nil
+}
+
+// selectMembers returns the objects followed by the archive members needed to resolve the labels
+// they refer to. Members are searched in the order of the archives and can themselves pull in
+// other members of any archive.
+func selectMembers(objects []*Object, archives []*Archive) ([]*Object, error) {
+	defined := make(map[string]bool)
+	referenced := make(map[string]bool)
+	add := func(o *Object) error {
+		for _, symbol := range o.exports() {
+			defined[symbol] = true
+		}
+		references, err := o.references()
+		if err != nil {
+			return err
+		}
+		for _, symbol := range references {
+			referenced[symbol] = true
+		}
+		return nil
+	}
+	for _, o := range objects {
+		if err := add(o); err != nil {
+			return nil, err
+		}
+	}
+
+	selected := append([]*Object(nil), objects...)
+	linked := make(map[*Object]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, ar := range archives {
+			for _, m := range ar.Members {
+				if linked[m.Object] || !m.Object.resolvesAny(referenced, defined) {
+					continue
+				}
+				linked[m.Object] = true
+				changed = true
+				selected = append(selected, m.Object)
+				if err := add(m.Object); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return selected, nil
+}
+
+// resolvesAny returns true if the object exports a label that is referenced but not yet defined.
+func (o *Object) resolvesAny(referenced, defined map[string]bool) bool {
+	for _, symbol := range o.exports() {
+		if referenced[symbol] && !defined[symbol] {
+			return true
+		}
+	}
+	return false
+}
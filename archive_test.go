@@ -0,0 +1,86 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLinkArchive(t *testing.T) {
+	main := compile(t, "main.o", `
+	@MUL
+	0;JMP
+`)
+	lib := archive(t, "lib.a",
+		compile(t, "draw.o", "(DRAW)\n\t@SCREEN\n\tM=-1"),
+		compile(t, "mul.o", "(MUL)\n\t@ADD\n\t0;JMP"),
+		compile(t, "add.o", "(ADD)\n\tD=D+A"),
+	)
+
+	var got bytes.Buffer
+	var symbols strings.Builder
+	asm := Assembler{Symbols: &symbols}
+	err := asm.Link([]*Object{main}, &got, lib)
+	assertNoError(t, err)
+
+	want := `0000000000000010
+1110101010000111
+0000000000000100
+1110101010000111
+1110000010010000
+`
+	assertDeepEquals(t, "Link", "", got.String(), want)
+
+	wantSymbols := `MUL  2  label
+ADD  4  label
+`
+	assertDeepEquals(t, "Link", "", symbols.String(), wantSymbols)
+}
+
+func TestLinkArchiveErrors(t *testing.T) {
+	t.Run("IgnoreDuplicateExportedLabelsOfMembersNotLinked", func(t *testing.T) {
+		main := compile(t, "main.o", "(MUL)\n\t@ADD")
+		lib := archive(t, "lib.a",
+			compile(t, "add.o", "(ADD)\n\t@MUL"),
+			compile(t, "mul.o", "\n(MUL)\n\t@ADD"),
+		)
+		err := new(Assembler).Link([]*Object{main}, new(strings.Builder), lib)
+		assertNoError(t, err)
+	})
+
+	t.Run("RejectDuplicateExportedLabels", func(t *testing.T) {
+		main := compile(t, "main.o", "(MUL)\n\t@ADD")
+		lib := archive(t, "lib.a",
+			compile(t, "add.o", "(ADD)\n(MUL)"),
+		)
+		err := new(Assembler).Link([]*Object{main}, new(strings.Builder), lib)
+		assertError(t, err)
+
+		want := `failed to link: duplicate symbol "MUL" exported by main.o at line 1 and by lib.a(add.o) at line 2`
+		assertDeepEquals(t, "Link", "", err.Error(), want)
+	})
+
+	t.Run("RejectDuplicateMembers", func(t *testing.T) {
+		o := compile(t, "add.o", "(ADD)")
+		err := WriteArchive(new(strings.Builder), []*Object{o, o})
+		assertError(t, err)
+	})
+
+	t.Run("RejectUnknownFormat", func(t *testing.T) {
+		_, err := ReadArchive(strings.NewReader(`{"format": "hack-archive/0", "members": []}`), "lib.a")
+		assertError(t, err)
+	})
+}
+
+// archive writes the objects into an archive and reads it back.
+func archive(t *testing.T, name string, objects ...*Object) *Archive {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := WriteArchive(&buf, objects)
+	assertNoError(t, err)
+
+	ar, err := ReadArchive(&buf, name)
+	assertNoError(t, err)
+	return ar
+}
@@ -3,12 +3,14 @@
 package hack
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"unicode"
 )
 
@@ -16,87 +18,418 @@ type instruction interface {
 	Instruction()
 }
 
-// A-instruction represents a constant or symbol which can be pre- or user-defined.
+// A-instruction represents a constant or symbol which can be pre- or user-defined. It can also hold
+// an expression over constants and symbols which is evaluated once labels have been resolved.
 type aInstruction struct {
 	Literal  string
 	IsSymbol bool
 	Value    uint16
+	Expr     expr
+	Pos      pos
 }
 
 func (a aInstruction) Instruction() {}
 
+func (a aInstruction) String() string {
+	if a.Expr != nil {
+		return "@" + a.Expr.String()
+	}
+	if a.Literal == "" {
+		return "@" + strconv.Itoa(int(a.Value))
+	}
+	return "@" + a.Literal
+}
+
 // C-instruction represents a computation in the form of dest=comp;jump.
 type cInstruction struct {
 	Dest string
 	Comp string
 	Jump string
+	Pos  pos
 }
 
 func (c cInstruction) Instruction() {}
 
+func (c cInstruction) String() string {
+	s := c.Comp
+	if c.Dest != "" {
+		s = c.Dest + "=" + s
+	}
+	if c.Jump != "" {
+		s += ";" + c.Jump
+	}
+	return s
+}
+
 // label represents a label declaration. It is a pseudo-instruction that will not be translated into
 // machine code. It is used as a reference to instruction memory location holding the next command
 // in the program.
 type label struct {
 	Literal string
+	// Generated is true for labels generated by the structured control-flow directives.
+	Generated bool
+	Pos       pos
 }
 
 func (l label) Instruction() {}
 
+func (l label) String() string {
+	return "(" + l.Literal + ")"
+}
+
+// Assembler translates hack assembly into machine code for the hack CPU. The zero value is ready to
+// use.
+type Assembler struct {
+	// FS is used to resolve .include directives. Included files are resolved relative to the
+	// directory of the including file. Includes are rejected if FS is nil.
+	FS fs.FS
+	// Listing receives a listing of the program if not nil. Every line of the listing shows the ROM
+	// address, the machine code, the instruction and its source position.
+	Listing io.Writer
+	// Symbols receives the symbol table of the program if not nil. It lists every label and
+	// variable with its address in the order they were declared.
+	Symbols io.Writer
+	// MemoryMap receives the RAM allocated to variables and blocks if not nil. Every line shows the
+	// address range, the size, the name, the kind and the source position of an allocation.
+	MemoryMap io.Writer
+	// StackPointer is the symbol holding the address of the top of the stack used by the PUSH and
+	// POP pseudo-instructions. It defaults to SP.
+	StackPointer string
+	// Defines holds the names tested by the conditional assembly directives .ifdef and .ifndef.
+	// Names that are defined with a non-empty value are also declared as constants.
+	Defines map[string]string
+	// Strict rejects references to symbols that are neither pre-defined, labels, constants nor
+	// variables declared using .var instead of implicitly allocating them as variables.
+	Strict bool
+	// Warnings receives warnings like declared variables that are never used if not nil. Every
+	// warning shows the ID of its rule which is used to suppress it using a comment like
+	// // hack:ignore RULE_ID on the same or the previous line or // hack:ignore-file RULE_ID.
+	Warnings io.Writer
+	// WarningsAsErrors fails the assembly if there are warnings that are not suppressed. No machine
+	// code is written in that case.
+	WarningsAsErrors bool
+	// Filler is the instruction used to pad the program for the placement directives .org and
+	// .align. It defaults to 0, a C-instruction without any effect.
+	Filler string
+	// Entry declares the entry point of the program like the arguments of an .entry directive, for
+	// example "Main SP=256". It takes precedence over any .entry directive.
+	Entry string
+	// Optimize applies peephole optimizations like removing redundant loads and jumps to the next
+	// instruction before the program is translated into machine code.
+	Optimize bool
+	// Optimizations receives the number of instructions saved by each optimization if not nil and
+	// Optimize is set.
+	Optimizations io.Writer
+	// DropUnreachable removes instructions that cannot be executed as they are not reached from ROM
+	// address 0 or from any label loaded into A. Unreachable instructions are reported as warnings
+	// either way.
+	DropUnreachable bool
+	// Rules are user-defined peephole rewrites read using ReadRules. They are applied before the
+	// optimizations enabled by Optimize.
+	Rules []*Rule
+	// RuleMatches receives every match of Rules with its source position and replacement if not nil.
+	RuleMatches io.Writer
+	// RulesDryRun reports the matches of Rules to RuleMatches without applying them.
+	RulesDryRun bool
+
+	// suppressions are the warnings suppressed by comments in the program that is assembled.
+	suppressions []suppression
+	// warnings counts the warnings that are not suppressed.
+	warnings int
+}
+
 // Assemble translates hack assembly into machine code for the hack CPU. The machine code is written
 // as text instead of binary as that is what was required in https://www.nand2tetris.org/project06.
 func Assemble(r io.Reader, w io.Writer) error {
-	instructions, err := parse(r)
+	return new(Assembler).Assemble(r, w)
+}
+
+// Assemble translates hack assembly read from r into machine code written to w. Includes are
+// resolved relative to the root of a.FS.
+func (a *Assembler) Assemble(r io.Reader, w io.Writer) error {
+	instructions, err := a.parse(r, "")
+	if err != nil {
+		return err
+	}
+
+	return a.assemble(instructions, w)
+}
+
+// AssembleFile translates the hack assembly in file name of a.FS into machine code written to w.
+func (a *Assembler) AssembleFile(name string, w io.Writer) error {
+	if a.FS == nil {
+		return fmt.Errorf("failed to open %q: no file system to read from", name)
+	}
+	f, err := a.FS.Open(name)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	err = code(instructions, w)
+	instructions, err := a.parse(f, name)
 	if err != nil {
 		return err
 	}
 
+	return a.assemble(instructions, w)
+}
+
+// assemble includes the routines of the standard library that instructions refer to, replaces the
+// entry point by a prologue jumping to it, warns about unused symbols, eliminates dead code, applies
+// the user-defined rules, optimizes them if requested and translates them into machine code written
+// to w.
+func (a *Assembler) assemble(instructions []instruction, w io.Writer) error {
+	a.warnings = 0
+	instructions, err := includeStd(instructions)
+	if err != nil {
+		return err
+	}
+	instructions, err = a.bootstrap(instructions)
+	if err != nil {
+		return err
+	}
+	a.warnSymbols(instructions)
+	instructions = a.eliminateDeadCode(instructions)
+	instructions, err = a.applyRules(instructions)
+	if err != nil {
+		return err
+	}
+	var result *optimization
+	if a.Optimize {
+		instructions, result = a.optimize(instructions)
+	}
+
+	// warnings about unused variables are only known once the machine code has been written
+	out := w
+	var machine bytes.Buffer
+	if a.WarningsAsErrors {
+		out = &machine
+	}
+	if err := a.code(instructions, out); err != nil {
+		return err
+	}
+	if a.WarningsAsErrors {
+		if a.warnings > 0 {
+			return fmt.Errorf("failed to assemble: %d warnings treated as errors", a.warnings)
+		}
+		if _, err := machine.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	if result != nil && a.Optimizations != nil {
+		return result.write(a.Optimizations)
+	}
 	return nil
 }
 
-// parse parses hack assembly into instructions including the pseudo-instruction label. Symbolic
-// declarations in labels or symbolic references in A-instructions will not have been resolved at
-// this stage.
-func parse(r io.Reader) ([]instruction, error) {
+// parse parses hack assembly into instructions including the pseudo-instruction label. Conditional
+// assembly is evaluated, included files are read and macros are expanded before the instructions
+// are parsed. Defines with a value are turned into constants. Symbolic declarations in labels or
+// symbolic references in A-instructions will not have been resolved at this stage.
+func (a *Assembler) parse(r io.Reader, file string) ([]instruction, error) {
+	lines, suppressions, err := a.source(r, file)
+	if err != nil {
+		return nil, err
+	}
+	a.suppressions = suppressions
+	return a.parseSource(lines)
+}
+
+// source reads the lines of hack assembly from r with conditional assembly evaluated, included files
+// read and macros expanded. It returns the warnings suppressed by comments which are read before
+// macros are expanded so they also apply to macro invocations.
+func (a *Assembler) source(r io.Reader, file string) ([]line, []suppression, error) {
+	lines, err := readLines(r, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, err = a.preprocess(lines, []string{file})
+	if err != nil {
+		return nil, nil, err
+	}
+	suppressions, err := readSuppressions(lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	defines, err := defineConstants(a.Defines)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, err = expandMacros(lines, defines)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lines, suppressions, nil
+}
+
+// parseSource parses the lines returned by source into instructions preceded by the constants of
+// the defines.
+func (a *Assembler) parseSource(lines []line) ([]instruction, error) {
+	defines, err := defineConstants(a.Defines)
+	if err != nil {
+		return nil, err
+	}
+	instructions, err := a.parseLines(lines)
+	if err != nil {
+		return nil, err
+	}
+	return append(defines, instructions...), nil
+}
+
+// parseLines parses lines into instructions. Every instruction records the position of the line
+// it was parsed from. Local labels are qualified with the global label they are scoped to.
+func (a *Assembler) parseLines(lines []line) ([]instruction, error) {
 	var instructions []instruction
-	s := bufio.NewScanner(r)
-	for s.Scan() {
-		line, _, _ := strings.Cut(s.Text(), "//")
-		command := strings.TrimSpace(line)
+	var scope string
+	var flow controlFlow
+	for _, l := range lines {
+		command := l.Command()
 
 		if len(command) == 0 {
 			continue
 		}
-		if command[0] == '@' {
-			ins, err := parseAInstruction(command)
-			if err != nil {
-				return nil, err
-			}
-			instructions = append(instructions, ins)
-		} else if command[0] == '(' {
-			ins, err := parseLabel(command)
-			if err != nil {
-				return nil, err
-			}
-			instructions = append(instructions, ins)
+		var parsed []instruction
+		var err error
+		if isControlFlow(directive(l)) {
+			parsed, err = flow.parse(command, l.Pos)
 		} else {
-			ins, err := parseCInstruction(command)
-			if err != nil {
-				return nil, err
+			parsed, err = a.parseCommand(command)
+		}
+		if err != nil {
+			return nil, errorf(l.Pos, "%v", err)
+		}
+
+		for _, ins := range parsed {
+			switch ins := ins.(type) {
+			case *aInstruction:
+				if ins.IsSymbol && isLocal(ins.Literal) {
+					ins.Literal = scope + ins.Literal
+				}
+				if ins.Expr != nil {
+					ins.Expr = qualifyLocals(ins.Expr, scope)
+				}
+				ins.Pos = l.Pos
+			case *cInstruction:
+				ins.Pos = l.Pos
+			case *label:
+				if isLocal(ins.Literal) {
+					ins.Literal = scope + ins.Literal
+				} else if l.Pos.Call == nil && command[0] == '(' {
+					// labels declared by macros are renamed per expansion and labels generated by
+					// directives are not declared by the user. Both would otherwise silently
+					// change the scope of the code that follows.
+					scope = ins.Literal
+				}
+				ins.Pos = l.Pos
+				if err := flow.declare(ins); err != nil {
+					return nil, err
+				}
+			case *constant:
+				if ins.IsSymbol && isLocal(ins.Literal) {
+					ins.Literal = scope + ins.Literal
+				}
+				if ins.Expr != nil {
+					ins.Expr = qualifyLocals(ins.Expr, scope)
+				}
+				ins.Pos = l.Pos
+			case *variable:
+				if ins.Address != nil {
+					ins.Address = qualifyLocals(ins.Address, scope)
+				}
+				ins.Pos = l.Pos
+			case *array:
+				ins.Size = qualifyLocals(ins.Size, scope)
+				ins.Pos = l.Pos
+			case *placement:
+				ins.Pos = l.Pos
+			case *entry:
+				ins.Pos = l.Pos
 			}
 			instructions = append(instructions, ins)
 		}
 	}
+	if err := flow.close(); err != nil {
+		return nil, err
+	}
 
-	// TODO error handling of s.Error()
 	return instructions, nil
 }
 
+// parseCommand parses a single command into instructions. Most commands result in a single
+// instruction while pseudo-instructions and some A-instructions are expanded into multiple.
+func (a *Assembler) parseCommand(command string) ([]instruction, error) {
+	switch command[0] {
+	case '@':
+		ins, err := parseAInstruction(command)
+		if err != nil {
+			return nil, err
+		}
+		return expandAInstruction(ins), nil
+	case '(':
+		ins, err := parseLabel(command)
+		if err != nil {
+			return nil, err
+		}
+		return []instruction{ins}, nil
+	case '.':
+		ins, err := parseDirective(command)
+		if err != nil {
+			return nil, err
+		}
+		return []instruction{ins}, nil
+	}
+
+	mnemonic, operands := cutField(command)
+	if expand, ok := pseudoInstructions[mnemonic]; ok {
+		ins, err := expand(a.stackPointer(), splitOperands(operands))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pseudo-instruction %q: %v", command, err)
+		}
+		return ins, nil
+	}
+
+	ins, err := parseCInstruction(command)
+	if err != nil {
+		return nil, err
+	}
+	return []instruction{ins}, nil
+}
+
+// parseDirective parses a directive that results in a pseudo-instruction.
+func parseDirective(command string) (instruction, error) {
+	switch d, _ := cutField(command); d {
+	case ".equ", ".set":
+		return parseConstant(command)
+	case ".var":
+		return parseVariable(command)
+	case ".block":
+		return parseArray(command)
+	case ".org", ".align":
+		return parsePlacement(command)
+	case ".entry":
+		return parseEntry(command)
+	default:
+		return nil, fmt.Errorf("failed to parse directive %q: unknown directive", d)
+	}
+}
+
+// isLocal returns true if the symbol refers to a local label. Local labels start with a dot and are
+// scoped to the nearest preceding global label. They are qualified as GLOBAL.local so that the same
+// local label can be declared in the scope of every global label.
+func isLocal(symbol string) bool {
+	return strings.HasPrefix(symbol, ".")
+}
+
+// qualifyLocals qualifies all local labels in e with given scope.
+func qualifyLocals(e expr, scope string) expr {
+	return mapSymbols(e, func(symbol string) string {
+		if isLocal(symbol) {
+			return scope + symbol
+		}
+		return symbol
+	})
+}
+
 func parseAInstruction(in string) (*aInstruction, error) {
 	if len(in) < 2 {
 		return nil, errors.New("failed to parse A-instruction: @ needs to be followed by a constant or symbol")
@@ -104,21 +437,58 @@ func parseAInstruction(in string) (*aInstruction, error) {
 	in = in[1:] // drop the @
 
 	// symbols cannot start with a digit; as a starting digit indicates a constant
-	if unicode.IsDigit(rune(in[0])) {
-		v, err := strconv.ParseUint(in, 10, 15)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse A-instruction: expected unsigned 15-bit value: %v", err)
-		}
-		return &aInstruction{Literal: in, Value: uint16(v)}, nil
+	if !unicode.IsDigit(rune(in[0])) && containsOnly(in, validSymbolChars) {
+		return &aInstruction{Literal: in, IsSymbol: true}, nil
 	}
 
-	ok := containsOnly(in, validSymbolChars)
-	if !ok {
-		return nil, errors.New(`failed to parse A-instruction: literal contains illegal character. A user-deﬁned symbol can be any sequence of letters, digits, underscore ( _ ),
-dot (.), dollar sign ($), and colon (:) that does not begin with a digit`)
+	return parseAExpression(in)
+}
+
+// parseAExpression parses an A-instruction holding a constant like @0x4000 or an expression like
+// @SCREEN+32. Expressions that do not refer to any symbol are evaluated right away. Their value can
+// be negative or need bit 15 in which case the A-instruction cannot be encoded as is but needs to
+// be expanded using expandAInstruction. Expressions that refer to symbols are evaluated once labels
+// have been resolved.
+func parseAExpression(in string) (*aInstruction, error) {
+	e, err := parseExpr(in)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to parse A-instruction: invalid expression %q: %v. A user-deﬁned symbol can be any sequence of letters, digits, underscore ( _ ),
+dot (.), dollar sign ($), and colon (:) that does not begin with a digit`, in, err)
+	}
+	if hasSymbols(e) {
+		return &aInstruction{Literal: in, Expr: e}, nil
 	}
 
-	return &aInstruction{Literal: in, IsSymbol: true}, nil
+	v, err := evalExpr(e, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse A-instruction %q: %v", in, err)
+	}
+	if v < -1<<15 || v > 1<<16-1 {
+		return nil, fmt.Errorf("failed to parse A-instruction %q: expression evaluates to %d which does not fit into 16 bits", in, v)
+	}
+	return &aInstruction{Literal: in, Value: uint16(v)}, nil
+}
+
+// expandAInstruction expands an A-instruction with a value that is negative or needs bit 15 into
+// an equivalent sequence of instructions. The A-instruction can only load unsigned 15-bit values.
+// Other values are loaded by negating or inverting an unsigned 15-bit value. For example @-1 is
+// expanded into @1 followed by A=-A. Only the A register is changed.
+func expandAInstruction(ins *aInstruction) []instruction {
+	if ins.IsSymbol || ins.Expr != nil || ins.Value&0x8000 == 0 {
+		return []instruction{ins}
+	}
+
+	load := &aInstruction{Pos: ins.Pos}
+	op := &cInstruction{Dest: "A", Pos: ins.Pos}
+	if negated := -ins.Value; negated&0x8000 == 0 {
+		load.Value = negated
+		op.Comp = "-A"
+	} else {
+		load.Value = ^ins.Value
+		op.Comp = "!A"
+	}
+	load.Literal = strconv.Itoa(int(load.Value))
+	return []instruction{load, op}
 }
 
 // validSymbolChars ensures that user-deﬁned symbol can only be any sequence of letters, digits,
@@ -209,41 +579,70 @@ var predefinedSymbols map[string]uint16 = map[string]uint16{
 
 // code translates instructions into machine code. Labels do not result in an instruction in machine
 // code. Symbolic references in A-instructions are resolved into memory addresses at this stage.
-func code(instructions []instruction, w io.Writer) error {
-	var nextVariableAddress uint16 = 16
-	var pc uint16
-	symbolTable := make(map[string]uint16)
-	for _, instruction := range instructions {
-		switch v := instruction.(type) {
-		case *label:
-			if _, ok := symbolTable[v.Literal]; ok {
-				return fmt.Errorf("failed to encode label %q: label re-declared", v.Literal)
-			}
-			if _, ok := predefinedSymbols[v.Literal]; ok {
-				return fmt.Errorf("failed to encode label: %q is a pre-defined symbol which cannot be used as a label", v.Literal)
-			}
-			symbolTable[v.Literal] = pc
-		default:
-			pc++
-		}
+func (a *Assembler) code(instructions []instruction, w io.Writer) error {
+	var listing *tabwriter.Writer
+	if a.Listing != nil {
+		listing = tabwriter.NewWriter(a.Listing, 0, 4, 2, ' ', 0)
 	}
-	for k, v := range predefinedSymbols {
-		symbolTable[k] = v
+
+	symbols, err := declare(instructions, a.Strict)
+	if err != nil {
+		return err
+	}
+	filler, fillerCode, err := a.filler()
+	if err != nil {
+		return err
 	}
 
+	var pc int
 	for _, instruction := range instructions {
 		switch ins := instruction.(type) {
-		case *aInstruction:
-			ains := ins
-			if ins.IsSymbol {
-				v, ok := symbolTable[ins.Literal]
-				if !ok {
-					v = nextVariableAddress
-					symbolTable[ins.Literal] = v
-					nextVariableAddress++
+		case *label:
+			if listing != nil {
+				fmt.Fprintf(listing, "%d\t\t%s\t%s\n", pc, ins, ins.Pos)
+			}
+		case *constant:
+			if listing != nil {
+				fmt.Fprintf(listing, "\t\t%s\t%s\n", ins, ins.Pos)
+			}
+		case *variable:
+			if listing != nil {
+				fmt.Fprintf(listing, "\t\t%s\t%s\n", ins, ins.Pos)
+			}
+		case *array:
+			if listing != nil {
+				fmt.Fprintf(listing, "\t\t%s\t%s\n", ins, ins.Pos)
+			}
+		case *placement:
+			if listing != nil {
+				fmt.Fprintf(listing, "%d\t\t%s\t%s\n", pc, ins, ins.Pos)
+			}
+			padding, err := ins.padding(pc)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < padding; i++ {
+				n, err := fmt.Fprintf(w, "%s\n", fillerCode)
+				if n != 17 {
+					return fmt.Errorf("failed to write entire filler %v: wrote %d instead of 17 bytes/chars", filler, n)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to write filler %v: %v", filler, err)
+				}
+				if listing != nil {
+					fmt.Fprintf(listing, "%d\t%s\t%s\t%s\n", pc, fillerCode, filler, ins.Pos)
 				}
-				ains = &aInstruction{Value: v}
+				pc++
 			}
+		case *aInstruction:
+			v, err := symbols.value(ins)
+			if err != nil {
+				return err
+			}
+			if v&0x8000 != 0 {
+				return errorf(ins.Pos, "failed to encode A-instruction %q: value %d is not an unsigned 15-bit value", ins, v)
+			}
+			ains := &aInstruction{Value: v}
 
 			n, err := fmt.Fprintf(w, "%016b\n", codeAInstruction(ains))
 			if n != 17 {
@@ -252,10 +651,26 @@ func code(instructions []instruction, w io.Writer) error {
 			if err != nil {
 				return fmt.Errorf("failed to write a-instruction %v: %v", ins, err)
 			}
+			if listing != nil {
+				fmt.Fprintf(listing, "%d\t%016b\t%s\t%s\n", pc, codeAInstruction(ains), ins, ins.Pos)
+			}
+			pc++
+		case *word:
+			n, err := fmt.Fprintf(w, "%016b\n", ins.Code)
+			if n != 17 {
+				return fmt.Errorf("failed to write entire instruction %v: wrote %d instead of 17 bytes/chars", ins, n)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write instruction %v: %v", ins, err)
+			}
+			if listing != nil {
+				fmt.Fprintf(listing, "%d\t%016b\t%s\t%s\n", pc, ins.Code, ins, ins.Pos)
+			}
+			pc++
 		case *cInstruction:
 			code, err := codeCInstruction(ins)
 			if err != nil {
-				return fmt.Errorf("failed to encode c-instruction %v: %v", ins, err)
+				return errorf(ins.Pos, "failed to encode c-instruction %q: %v", ins, err)
 			}
 			n, err := fmt.Fprintf(w, "%s\n", code)
 			if n != 17 {
@@ -264,9 +679,29 @@ func code(instructions []instruction, w io.Writer) error {
 			if err != nil {
 				return fmt.Errorf("failed to write c-instruction %v: %v", ins, err)
 			}
+			if listing != nil {
+				fmt.Fprintf(listing, "%d\t%s\t%s\t%s\n", pc, code, ins, ins.Pos)
+			}
+			pc++
 		}
 	}
 
+	for _, s := range symbols.unusedVariables(instructions) {
+		a.warnf(s.Pos, warnUnusedVariable, "%s %q is declared but not used", s.Kind, s.Name)
+	}
+	if listing != nil {
+		if err := listing.Flush(); err != nil {
+			return err
+		}
+	}
+	if a.Symbols != nil {
+		if err := writeSymbols(a.Symbols, symbols.symbols); err != nil {
+			return err
+		}
+	}
+	if a.MemoryMap != nil {
+		return writeMemoryMap(a.MemoryMap, symbols.ram.regions)
+	}
 	return nil
 }
 
@@ -176,6 +176,52 @@ M=D
 	// TODO add error test cases.
 }
 
+func TestListingShowsExpansionOfAInstructions(t *testing.T) {
+	in := `@-1
+D=A`
+	var listing bytes.Buffer
+	asm := Assembler{Listing: &listing}
+
+	err := asm.Assemble(strings.NewReader(in), new(bytes.Buffer))
+	assertNoError(t, err)
+
+	want := `0  0000000000000001  @1    line 1
+1  1110110011100000  A=-A  line 1
+2  1110110000010000  D=A   line 2
+`
+	assertDeepEquals(t, "Assemble", in, listing.String(), want)
+}
+
+func TestSymbols(t *testing.T) {
+	in := `
+(MAIN)
+	@.loop
+	0;JMP
+(.loop)
+	@counter
+	M=0
+.macro WAIT
+(.wait)
+	@.wait
+	0;JMP
+.endm
+	WAIT
+	@.loop
+`
+	var symbols bytes.Buffer
+	asm := Assembler{Symbols: &symbols}
+
+	err := asm.Assemble(strings.NewReader(in), new(bytes.Buffer))
+	assertNoError(t, err)
+
+	want := `MAIN              0   label
+MAIN.loop         2   label
+MAIN.wait$WAIT.1  4   label
+counter           16  variable
+`
+	assertDeepEquals(t, "Assemble", in, symbols.String(), want)
+}
+
 func TestParse(t *testing.T) {
 	tests := map[string]struct {
 		in   string
@@ -195,6 +241,7 @@ func TestParse(t *testing.T) {
 				&aInstruction{
 					Literal: "2",
 					Value:   2,
+					Pos:     pos{Line: 1},
 				},
 			},
 		},
@@ -204,6 +251,7 @@ func TestParse(t *testing.T) {
 				&aInstruction{
 					Literal: "2",
 					Value:   2,
+					Pos:     pos{Line: 1},
 				},
 			},
 		},
@@ -214,13 +262,32 @@ func TestParse(t *testing.T) {
 					Dest: "D",
 					Comp: "M",
 					Jump: "",
+					Pos:  pos{Line: 1},
 				},
 			},
 		},
+		"LocalLabelsAreScopedToPrecedingGlobalLabel": {
+			in: `
+(MAIN)
+	@.loop
+(.loop)
+(DRAW)
+(.loop)
+	@.loop
+`,
+			want: []instruction{
+				&label{Literal: "MAIN", Pos: pos{Line: 2}},
+				&aInstruction{Literal: "MAIN.loop", IsSymbol: true, Pos: pos{Line: 3}},
+				&label{Literal: "MAIN.loop", Pos: pos{Line: 4}},
+				&label{Literal: "DRAW", Pos: pos{Line: 5}},
+				&label{Literal: "DRAW.loop", Pos: pos{Line: 6}},
+				&aInstruction{Literal: "DRAW.loop", IsSymbol: true, Pos: pos{Line: 7}},
+			},
+		},
 	}
 
 	for _, tc := range tests {
-		got, err := parse(strings.NewReader(tc.in))
+		got, err := new(Assembler).parse(strings.NewReader(tc.in), "")
 		assertNoError(t, err)
 
 		assertDeepEquals(t, "Parse", tc.in, got, tc.want)
@@ -241,6 +308,48 @@ func TestParseAInstruction(t *testing.T) {
 				Value:   2,
 			},
 		},
+		"ParseHexConstant": {
+			in: `@0x4000`,
+			want: &aInstruction{
+				Literal: "0x4000",
+				Value:   16384,
+			},
+		},
+		"ParseBinaryConstant": {
+			in: `@0b1010`,
+			want: &aInstruction{
+				Literal: "0b1010",
+				Value:   10,
+			},
+		},
+		"ParseCharacter": {
+			in: `@'A'`,
+			want: &aInstruction{
+				Literal: "'A'",
+				Value:   65,
+			},
+		},
+		"ParseCharacterNewlineInHackCharacterSet": {
+			in: `@'\n'`,
+			want: &aInstruction{
+				Literal: `'\n'`,
+				Value:   128,
+			},
+		},
+		"ParseNegativeConstant": {
+			in: `@-2`,
+			want: &aInstruction{
+				Literal: "-2",
+				Value:   0xFFFE,
+			},
+		},
+		"ParseConstantNeedingBit15": {
+			in: `@32768`,
+			want: &aInstruction{
+				Literal: "32768",
+				Value:   0x8000,
+			},
+		},
 		"ParsePredifinedSymbol": {
 			in: `@R0`,
 			want: &aInstruction{
@@ -272,11 +381,17 @@ func TestParseAInstruction(t *testing.T) {
 		"Reject@WithoutSymbolOrConstant": {
 			in: `@`,
 		},
-		"RejectNegativeConstants": {
-			in: `@-2`,
+		"RejectConstantsExceeding16Bits": {
+			in: `@65536`,
 		},
-		"RejectConstantsExceeding15Bits": {
-			in: `@32768`,
+		"RejectNegativeConstantsExceeding16Bits": {
+			in: `@-32769`,
+		},
+		"RejectInvalidHexConstant": {
+			in: `@0x4G`,
+		},
+		"RejectNonPrintableCharacter": {
+			in: "@'\t'",
 		},
 		"RejectFloats": {
 			in: `@3.14`,
@@ -297,6 +412,59 @@ func TestParseAInstruction(t *testing.T) {
 	}
 }
 
+func TestExpandAInstruction(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want []instruction
+	}{
+		"15BitConstantIsNotExpanded": {
+			in: `@0x7FFF`,
+			want: []instruction{
+				&aInstruction{Literal: "0x7FFF", Value: 32767},
+			},
+		},
+		"NegativeConstantIsNegated": {
+			in: `@-1`,
+			want: []instruction{
+				&aInstruction{Literal: "1", Value: 1},
+				&cInstruction{Dest: "A", Comp: "-A"},
+			},
+		},
+		"SmallestNegativeConstantIsInverted": {
+			in: `@-32768`,
+			want: []instruction{
+				&aInstruction{Literal: "32767", Value: 32767},
+				&cInstruction{Dest: "A", Comp: "!A"},
+			},
+		},
+		"ConstantNeedingBit15IsInverted": {
+			in: `@0xFFF0`,
+			want: []instruction{
+				&aInstruction{Literal: "16", Value: 16},
+				&cInstruction{Dest: "A", Comp: "-A"},
+			},
+		},
+		"ConstantWithOnlyBit15IsInverted": {
+			in: `@0x8000`,
+			want: []instruction{
+				&aInstruction{Literal: "32767", Value: 32767},
+				&cInstruction{Dest: "A", Comp: "!A"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ins, err := parseAInstruction(tc.in)
+			assertNoError(t, err)
+
+			got := expandAInstruction(ins)
+
+			assertDeepEquals(t, "expandAInstruction", tc.in, got, tc.want)
+		})
+	}
+}
+
 func TestParseCInstruction(t *testing.T) {
 	tests := map[string]struct {
 		in   string
@@ -480,7 +648,7 @@ func TestCode(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			b := new(bytes.Buffer)
-			err := code(tc.in, b)
+			err := new(Assembler).code(tc.in, b)
 			assertNoError(t, err)
 
 			// allow newlines to align tc.want machine code when tests include multiple instructions
@@ -516,7 +684,7 @@ func TestCode(t *testing.T) {
 	for name, tc := range errTests {
 		t.Run(name, func(t *testing.T) {
 			b := new(bytes.Buffer)
-			err := code(tc.in, b)
+			err := new(Assembler).code(tc.in, b)
 			assertError(t, err)
 		})
 	}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"teleivo/nand2tetris/hack-assembler"
+)
+
+func main() {
+	if err := run(os.Args); err != nil {
+		if errors.Is(err, hack.ErrNotEquivalent) || errors.Is(err, hack.ErrLintFailed) {
+			// the counterexample or findings have already been printed
+			os.Exit(1)
+		}
+		fmt.Printf("assembly failed due to:\n%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected a command: asm, link, ar, fmt, lint, superopt or equiv")
+	}
+
+	switch args[1] {
+	case "asm":
+		return runAsm(args[1:])
+	case "link":
+		return runLink(args[1:])
+	case "ar":
+		return runAr(args[1:])
+	case "fmt":
+		return runFmt(args[1:])
+	case "lint":
+		return runLint(args[1:])
+	case "superopt":
+		return runSuperopt(args[1:])
+	case "equiv":
+		return runEquiv(args[1:])
+	}
+	return fmt.Errorf("unknown command %q: expected asm, link, ar, fmt, lint, superopt or equiv", args[1])
+}
+
+// outputFlags are the flags shared by commands that produce a program.
+type outputFlags struct {
+	listing   *bool
+	symbols   *bool
+	memoryMap *bool
+	filler    *string
+	entry     *string
+	strict    *bool
+	optimize  *bool
+	dce       *bool
+	rules     *string
+	dryRun    *bool
+	werror    *bool
+}
+
+func newOutputFlags(flags *flag.FlagSet) outputFlags {
+	return outputFlags{
+		listing:   flags.Bool("l", false, "write a listing of the program to a '.lst' file next to the '.hack' file"),
+		symbols:   flags.Bool("s", false, "write the symbol table of the program to a '.sym' file next to the '.hack' file"),
+		memoryMap: flags.Bool("m", false, "write a map of the RAM allocated to variables and blocks to a '.map' file next to the '.hack' file"),
+		filler:    flags.String("fill", "0", "instruction used to pad the program for .org and .align"),
+		entry:     flags.String("entry", "", "jump to `LABEL` at ROM address 0, optionally initializing registers like \"Main SP=256\" first"),
+		strict:    flags.Bool("strict", false, "reject references to symbols that are not declared instead of allocating them as variables"),
+		optimize:  flags.Bool("O", false, "apply peephole optimizations and report the instructions saved"),
+		dce:       flags.Bool("dce", false, "remove instructions that are unreachable instead of only warning about them"),
+		rules:     flags.String("rules", "", "apply the peephole rewrite rules in `FILE`"),
+		dryRun:    flags.Bool("rules-dry-run", false, "print every match of the rules passed using -rules without applying them"),
+		werror:    flags.Bool("Werror", false, "fail the assembly if there are warnings that are not suppressed using // hack:ignore comments"),
+	}
+}
+
+// configure configures asm to write the outputs requested by the flags next to the program name.
+// The returned function closes the files that were created.
+func (o outputFlags) configure(asm *hack.Assembler, name string) (func(), error) {
+	asm.Filler = *o.filler
+	asm.Entry = *o.entry
+	asm.Strict = *o.strict
+	asm.Optimize = *o.optimize
+	asm.DropUnreachable = *o.dce
+	asm.Warnings = os.Stderr
+	asm.WarningsAsErrors = *o.werror
+	asm.Optimizations = os.Stderr
+	if *o.rules != "" {
+		rules, err := readRules(*o.rules)
+		if err != nil {
+			return nil, err
+		}
+		asm.Rules = rules
+		asm.RulesDryRun = *o.dryRun
+		if *o.dryRun {
+			asm.RuleMatches = os.Stdout
+		}
+	} else if *o.dryRun {
+		return nil, errors.New("-rules-dry-run requires the rules passed using -rules")
+	}
+
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	outputs := []struct {
+		enabled bool
+		ext     string
+		w       *io.Writer
+	}{
+		{*o.listing, ".lst", &asm.Listing},
+		{*o.symbols, ".sym", &asm.Symbols},
+		{*o.memoryMap, ".map", &asm.MemoryMap},
+	}
+	for _, out := range outputs {
+		if !out.enabled {
+			continue
+		}
+		f, err := os.Create(name + out.ext)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		files = append(files, f)
+		*out.w = f
+	}
+	return closeAll, nil
+}
+
+func runAsm(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	compile := flags.Bool("c", false, "write a relocatable object to a '.o' file instead of a program to be linked using the link command")
+	outputs := newOutputFlags(flags)
+	defines := make(defineFlag)
+	flags.Var(defines, "D", "define `NAME` or NAME=value for conditional assembly; can be repeated. Defines with a value are also declared as constants")
+	stackPointer := flags.String("sp", "SP", "symbol holding the address of the top of the stack used by PUSH and POP")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one arg pointing to an '.asm' file, got %d args instead", flags.NArg())
+	}
+
+	assemblyFile := flags.Arg(0)
+	name, _, found := strings.Cut(assemblyFile, ".asm")
+	if !found {
+		return fmt.Errorf("expected assembly file with filename ending in '.asm', instead got %q", assemblyFile)
+	}
+
+	// includes are resolved relative to the directory of the assembly file
+	asm := hack.Assembler{
+		FS:           os.DirFS(filepath.Dir(assemblyFile)),
+		StackPointer: *stackPointer,
+		Defines:      defines,
+	}
+
+	if *compile {
+		if *outputs.listing || *outputs.symbols || *outputs.memoryMap || *outputs.entry != "" || *outputs.optimize || *outputs.dce || *outputs.rules != "" {
+			return errors.New("-l, -s, -m, -entry, -O, -dce and -rules apply to programs and cannot be combined with -c. Pass them to link instead")
+		}
+		fout, err := os.Create(name + ".o")
+		if err != nil {
+			return err
+		}
+		defer fout.Close()
+
+		return asm.CompileFile(filepath.Base(assemblyFile), fout)
+	}
+
+	closeOutputs, err := outputs.configure(&asm, name)
+	if err != nil {
+		return err
+	}
+	defer closeOutputs()
+
+	machineFile := name + ".hack"
+	fout, err := os.Create(machineFile)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+
+	return asm.AssembleFile(filepath.Base(assemblyFile), fout)
+}
+
+func runLink(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	out := flags.String("o", "", "write the program to `FILE` instead of a '.hack' file named after the first object")
+	outputs := newOutputFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("expected at least one arg pointing to an '.o' file")
+	}
+
+	// archives only contribute the members needed by the objects regardless of their position
+	var objects []*hack.Object
+	var archives []*hack.Archive
+	for _, file := range flags.Args() {
+		if strings.HasSuffix(file, ".a") {
+			ar, err := readArchive(file)
+			if err != nil {
+				return err
+			}
+			archives = append(archives, ar)
+			continue
+		}
+		o, err := readObject(file)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, o)
+	}
+
+	machineFile := *out
+	if machineFile == "" {
+		machineFile = strings.TrimSuffix(flags.Arg(0), ".o") + ".hack"
+	}
+	var asm hack.Assembler
+	closeOutputs, err := outputs.configure(&asm, strings.TrimSuffix(machineFile, ".hack"))
+	if err != nil {
+		return err
+	}
+	defer closeOutputs()
+
+	fout, err := os.Create(machineFile)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+
+	return asm.Link(objects, fout, archives...)
+}
+
+func runAr(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 || !strings.HasSuffix(flags.Arg(0), ".a") {
+		return errors.New("expected an '.a' archive file followed by the '.o' files to add to it")
+	}
+
+	var objects []*hack.Object
+	for _, file := range flags.Args()[1:] {
+		o, err := readObject(file)
+		if err != nil {
+			return err
+		}
+		o.Name = filepath.Base(file)
+		objects = append(objects, o)
+	}
+
+	fout, err := os.Create(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+
+	return hack.WriteArchive(fout, objects)
+}
+
+func runFmt(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	write := flags.Bool("w", false, "write the formatted program back to its file instead of to stdout")
+	list := flags.Bool("l", false, "list the files whose formatting differs from the canonical layout")
+	diff := flags.Bool("d", false, "print a diff of the changes formatting makes instead of the formatted program")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		if *write {
+			return errors.New("cannot use -w when formatting stdin")
+		}
+		return formatFile("<stdin>", os.Stdin, false, *list, *diff)
+	}
+
+	for _, file := range flags.Args() {
+		if !strings.HasSuffix(file, ".asm") {
+			return fmt.Errorf("expected a file with filename ending in '.asm', instead got %q", file)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = formatFile(file, f, *write, *list, *diff)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatFile formats the program read from r. The formatted program is written to stdout unless it
+// is written back to the file, the file is listed or the changes are printed as a diff.
+func formatFile(file string, r io.Reader, write, list, diff bool) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var formatted bytes.Buffer
+	if err := hack.Format(bytes.NewReader(src), &formatted); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	changed := !bytes.Equal(src, formatted.Bytes())
+	if list && changed {
+		fmt.Println(file)
+	}
+	if write && changed {
+		if err := os.WriteFile(file, formatted.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	if diff && changed {
+		writeDiff(os.Stdout, file, string(src), formatted.String())
+	}
+	if !list && !write && !diff {
+		_, err = os.Stdout.Write(formatted.Bytes())
+	}
+	return err
+}
+
+// writeDiff writes a unified diff of the lines of a and b to w.
+func writeDiff(w io.Writer, file, a, b string) {
+	lines := func(s string) []string {
+		l := strings.SplitAfter(s, "\n")
+		if l[len(l)-1] == "" {
+			l = l[:len(l)-1]
+		}
+		return l
+	}
+	x, y := lines(a), lines(b)
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// edit is a line of the diff prefixed by ' ', '-' or '+'
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i, j = i+1, j+1
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", file, file)
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+		// a hunk extends until more than twice the context lines are unchanged
+		end := start
+		for unchanged := 0; end < len(edits) && unchanged <= 2*context; end++ {
+			if edits[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		from, to := max(start-context, 0), end
+		for to > start && edits[to-1].op == ' ' {
+			to--
+		}
+		to = min(to+context, len(edits))
+
+		var removed, added int
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				removed++
+			}
+			if e.op != '-' {
+				added++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", edits[from].i+1, removed, edits[from].j+1, added)
+		for _, e := range edits[from:to] {
+			fmt.Fprintf(w, "%c%s", e.op, e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+		start = to
+	}
+}
+
+func runLint(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	configFile := flags.String("config", "", "enable, disable or change the severity of rules as configured in `FILE`")
+	list := flags.Bool("list", false, "list the rules with their ID, severity and description")
+	defines := make(defineFlag)
+	flags.Var(defines, "D", "define `NAME` or NAME=value for conditional assembly; can be repeated")
+	stackPointer := flags.String("sp", "SP", "symbol holding the address of the top of the stack used by PUSH and POP")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	linter := hack.Linter{Defines: defines, StackPointer: *stackPointer}
+	if *configFile != "" {
+		f, err := os.Open(*configFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if linter.Config, err = hack.ReadLintConfig(f, *configFile); err != nil {
+			return err
+		}
+	}
+	if *list {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, rule := range hack.LintRules() {
+			severity, ok := linter.Config[rule.ID]
+			if !ok {
+				severity = rule.Severity
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", rule.ID, severity, rule.Description)
+		}
+		return w.Flush()
+	}
+	if flags.NArg() == 0 {
+		return errors.New("expected at least one arg pointing to an '.asm' file")
+	}
+
+	var failed error
+	for _, file := range flags.Args() {
+		if !strings.HasSuffix(file, ".asm") {
+			return fmt.Errorf("expected a file with filename ending in '.asm', instead got %q", file)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		// includes are resolved relative to the directory of the assembly file
+		linter.FS = os.DirFS(filepath.Dir(file))
+		err = linter.Lint(f, filepath.Base(file), os.Stdout)
+		f.Close()
+		if errors.Is(err, hack.ErrLintFailed) {
+			failed = err
+		} else if err != nil {
+			return err
+		}
+	}
+	return failed
+}
+
+func runSuperopt(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	maxLength := flags.Int("max", 2, "maximum number of instructions of an equivalent sequence")
+	tests := flags.Int("n", 10000, "number of random initial states equivalent sequences are verified on")
+	dead := flags.String("dead", "", "registers `A,D` whose value after the snippet is not used")
+	seed := flags.Int64("seed", 0, "seed of the random initial states")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one arg pointing to an '.asm' file holding the snippet, got %d args instead", flags.NArg())
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := hack.Superoptimizer{MaxLength: *maxLength, Tests: *tests, Dead: *dead, Seed: *seed}
+	return s.Search(f, os.Stdout)
+}
+
+func runEquiv(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	cycles := flags.Int("cycles", 100000, "maximum number of cycles each program runs for")
+	inputs := flags.String("inputs", "R0-R15", "`ADDRESSES` set to random values or to every combination of -values")
+	random := flags.Int("n", 0, "number of initial states with random inputs; defaults to 100 unless -values or -states are given")
+	values := flags.String("values", "", "check every combination of `VALUES` like -2:2,100 at the inputs")
+	statesFile := flags.String("states", "", "check the initial states in `FILE`, one per line like R0=7 R1=-3")
+	compare := flags.String("compare", "0-24575", "`ADDRESSES` compared once the programs halted")
+	seed := flags.Int64("seed", 0, "seed of the random initial states")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected two args pointing to '.asm' or '.hack' files, got %d args instead", flags.NArg())
+	}
+
+	checker := hack.EquivalenceChecker{Cycles: *cycles, Random: *random, Seed: *seed}
+	var err error
+	if checker.Inputs, err = hack.ParseAddressRanges(*inputs); err != nil {
+		return err
+	}
+	if checker.Compare, err = hack.ParseAddressRanges(*compare); err != nil {
+		return err
+	}
+	if *values != "" {
+		if checker.Values, err = parseValues(*values); err != nil {
+			return err
+		}
+	}
+	if *statesFile != "" {
+		f, err := os.Open(*statesFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if checker.States, err = hack.ReadStates(f); err != nil {
+			return fmt.Errorf("%s: %v", *statesFile, err)
+		}
+	}
+
+	a, err := machineCode(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := machineCode(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	return checker.Check(a, b, os.Stdout)
+}
+
+// machineCode returns the machine code of an '.hack' file or assembles an '.asm' file.
+func machineCode(file string) (io.Reader, error) {
+	if strings.HasSuffix(file, ".hack") {
+		code, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(code), nil
+	}
+	if !strings.HasSuffix(file, ".asm") {
+		return nil, fmt.Errorf("expected a file with filename ending in '.asm' or '.hack', instead got %q", file)
+	}
+	var machine bytes.Buffer
+	asm := hack.Assembler{FS: os.DirFS(filepath.Dir(file))}
+	if err := asm.AssembleFile(filepath.Base(file), &machine); err != nil {
+		return nil, err
+	}
+	return &machine, nil
+}
+
+// parseValues parses a comma separated list of values or ranges of values like -2:2,100.
+func parseValues(s string) ([]uint16, error) {
+	var values []uint16
+	for _, field := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(field), ":")
+		if !isRange {
+			to = from
+		}
+		start, err := strconv.ParseInt(from, 0, 17)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q: expected a 16-bit value or a range like -2:2", field)
+		}
+		end, err := strconv.ParseInt(to, 0, 17)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid value %q: expected a 16-bit value or a range like -2:2", field)
+		}
+		for v := start; v <= end; v++ {
+			values = append(values, uint16(v))
+		}
+	}
+	return values, nil
+}
+
+func readObject(file string) (*hack.Object, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return hack.ReadObject(f, file)
+}
+
+func readRules(file string) ([]*hack.Rule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return hack.ReadRules(f, file)
+}
+
+func readArchive(file string) (*hack.Archive, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return hack.ReadArchive(f, file)
+}
+
+// defineFlag collects the defines passed as -D NAME or -D NAME=value.
+type defineFlag map[string]string
+
+func (d defineFlag) String() string {
+	var defines []string
+	for name, value := range d {
+		defines = append(defines, name+"="+value)
+	}
+	return strings.Join(defines, ",")
+}
+
+func (d defineFlag) Set(define string) error {
+	name, value, _ := strings.Cut(define, "=")
+	if name == "" {
+		return fmt.Errorf("expected NAME or NAME=value, instead got %q", define)
+	}
+	d[name] = value
+	return nil
+}
@@ -0,0 +1,118 @@
+package hack
+
+import (
+	"fmt"
+	"sort"
+)
+
+// condition is an open block of conditional assembly or a structured .if block. Structured .if
+// blocks share .else and .endif with conditional assembly and need to be tracked so that each
+// .else and .endif is matched with the right block.
+type condition struct {
+	Directive string
+	// Active is true if the lines in the current branch of the block are assembled.
+	Active bool
+	// ParentActive is true if the lines surrounding the block are assembled.
+	ParentActive bool
+	HasElse      bool
+	Pos          pos
+}
+
+// evalConditionals evaluates the conditional assembly directives
+//
+//	.ifdef NAME ... .else ... .endif
+//	.ifndef NAME ... .else ... .endif
+//
+// against given defines. Lines in branches that are not assembled are dropped without reporting any
+// errors besides unbalanced blocks. Lines of structured .if blocks are kept as is.
+func evalConditionals(lines []line, defines map[string]string) ([]line, error) {
+	var out []line
+	var open []*condition
+	active := func() bool {
+		return len(open) == 0 || open[len(open)-1].Active
+	}
+
+	for _, l := range lines {
+		switch d := directive(l); d {
+		case ".ifdef", ".ifndef":
+			c := &condition{Directive: d, ParentActive: active(), Pos: l.Pos}
+			if c.ParentActive {
+				_, name := cutField(l.Command())
+				if !isSymbol(name) {
+					return nil, errorf(l.Pos, "failed to parse %s: expected a name, instead got %q", d, name)
+				}
+				_, defined := defines[name]
+				c.Active = defined == (d == ".ifdef")
+			}
+			open = append(open, c)
+		case ".if":
+			if active() {
+				out = append(out, l)
+			}
+			open = append(open, &condition{Directive: d, Active: active(), Pos: l.Pos})
+		case ".else":
+			if len(open) == 0 || open[len(open)-1].Directive == ".if" {
+				if active() {
+					out = append(out, l)
+				}
+				continue
+			}
+			c := open[len(open)-1]
+			if c.HasElse && c.ParentActive {
+				return nil, errorf(l.Pos, "failed to parse .else: %s at %s already has an .else", c.Directive, c.Pos)
+			}
+			c.HasElse = true
+			c.Active = c.ParentActive && !c.Active
+		case ".endif":
+			if len(open) == 0 {
+				// the structured control flow reports the missing .if
+				out = append(out, l)
+				continue
+			}
+			c := open[len(open)-1]
+			open = open[:len(open)-1]
+			if c.Directive == ".if" && c.Active {
+				out = append(out, l)
+			}
+		default:
+			if active() {
+				out = append(out, l)
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		if c := open[i]; c.Directive != ".if" {
+			return nil, errorf(c.Pos, "failed to parse %s: missing .endif", c.Directive)
+		}
+	}
+	return out, nil
+}
+
+// defineConstants returns constants for all defines that have a value.
+func defineConstants(defines map[string]string) ([]instruction, error) {
+	var constants []instruction
+	for _, name := range sortedKeys(defines) {
+		value := defines[name]
+		if value == "" {
+			continue
+		}
+		c, err := parseConstant(".equ " + name + " " + value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to define %s=%s: %v", name, value, err)
+		}
+		c.Pos = pos{File: "-D " + name}
+		constants = append(constants, c)
+	}
+	return constants, nil
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
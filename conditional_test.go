@@ -0,0 +1,158 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEvalConditionals(t *testing.T) {
+	defines := map[string]string{"DEBUG": ""}
+	tests := map[string]struct {
+		in   string
+		want []string
+	}{
+		"Ifdef": {
+			in: `
+.ifdef DEBUG
+	@1
+.else
+	@2
+.endif
+`,
+			want: []string{"@1"},
+		},
+		"Ifndef": {
+			in: `
+.ifndef DEBUG
+	@1
+.else
+	@2
+.endif
+`,
+			want: []string{"@2"},
+		},
+		"Nested": {
+			in: `
+.ifdef RELEASE
+	.ifdef DEBUG
+		@1
+	.else
+		@2
+	.endif
+.else
+	.ifdef DEBUG
+		@3
+	.endif
+.endif
+`,
+			want: []string{"@3"},
+		},
+		"StructuredIfIsKept": {
+			in: `
+.ifdef DEBUG
+	.if D>0
+		@1
+	.else
+		@2
+	.endif
+.else
+	.if D>0
+		@3
+	.endif
+.endif
+`,
+			want: []string{".if D>0", "@1", ".else", "@2", ".endif"},
+		},
+		"SkippedRegionsAreNotDiagnosed": {
+			in: `
+.ifdef RELEASE
+	.include missing.asm
+	@2A
+	.ifdef
+	.else
+	.else
+	.endif
+.endif
+`,
+			want: nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lines, err := readLines(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			got, err := evalConditionals(lines, defines)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "evalConditionals", tc.in, commands(got), tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingEndif": {
+			in: ".ifdef DEBUG",
+		},
+		"RejectMissingEndifInSkippedRegion": {
+			in: ".ifdef RELEASE\n.ifdef DEBUG\n.endif",
+		},
+		"RejectMissingName": {
+			in: ".ifdef\n.endif",
+		},
+		"RejectDuplicateElse": {
+			in: ".ifdef DEBUG\n.else\n.else\n.endif",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			lines, err := readLines(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			_, err = evalConditionals(lines, defines)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleWithDefines(t *testing.T) {
+	in := `
+.ifdef TRACE
+	@TRACE_ADDRESS
+	M=D
+.endif
+	@ROWS
+`
+	tests := map[string]struct {
+		defines map[string]string
+		want    string
+	}{
+		"Debug": {
+			defines: map[string]string{"TRACE": "", "TRACE_ADDRESS": "100", "ROWS": "0x10"},
+			want: `0000000001100100
+1110001100001000
+0000000000010000
+`,
+		},
+		"Release": {
+			defines: map[string]string{"ROWS": "16"},
+			want: `0000000000010000
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got bytes.Buffer
+			asm := Assembler{Defines: tc.defines}
+			err := asm.Assemble(strings.NewReader(in), &got)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", in, got.String(), tc.want)
+		})
+	}
+}
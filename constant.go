@@ -0,0 +1,97 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// constant represents the declaration of a named assembly-time constant using .equ NAME value. It is
+// a pseudo-instruction that will not be translated into machine code. References to the constant in
+// A-instructions are replaced by its value. Unlike variables constants do not occupy any RAM.
+type constant struct {
+	Name     string
+	Literal  string
+	IsSymbol bool
+	Value    uint16
+	Expr     expr
+	Pos      pos
+}
+
+func (c constant) Instruction() {}
+
+func (c constant) String() string {
+	return ".equ " + c.Name + " " + c.Literal
+}
+
+// parseConstant parses a constant declaration like .equ NAME value. The directive .set is accepted as
+// a synonym for .equ. The value is an unsigned 15-bit constant, a symbol or an expression.
+func parseConstant(in string) (*constant, error) {
+	directive, rest := cutField(in)
+	if directive != ".equ" && directive != ".set" {
+		return nil, errors.New("failed to parse constant: constant declarations need to start with .equ or .set")
+	}
+	name, value := cutField(rest)
+	if !isSymbol(name) || isLocal(name) {
+		return nil, fmt.Errorf("failed to parse constant %q: name must be a symbol that does not begin with a digit or a dot", name)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("failed to parse constant %q: missing value", name)
+	}
+
+	operand, err := parseAInstruction("@" + value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse constant %q: %v", name, err)
+	}
+	if !operand.IsSymbol && operand.Expr == nil && operand.Value&0x8000 != 0 {
+		return nil, fmt.Errorf("failed to parse constant %q: value %s is not an unsigned 15-bit value", name, value)
+	}
+	return &constant{Name: name, Literal: operand.Literal, IsSymbol: operand.IsSymbol, Value: operand.Value, Expr: operand.Expr}, nil
+}
+
+// resolveConstants resolves the values of constants in the order they were declared. Constants can
+// refer to labels, pre-defined symbols or other constants. Labels and pre-defined symbols are looked
+// up in symbolTable. The resolved constants are added to the symbolTable.
+func resolveConstants(constants []*constant, symbolTable map[string]uint16) error {
+	byName := make(map[string]*constant, len(constants))
+	for _, c := range constants {
+		byName[c.Name] = c
+	}
+
+	resolving := make(map[string]bool)
+	var resolve func(c *constant) error
+	lookup := func(symbol string) (int, error) {
+		if ref, ok := byName[symbol]; ok {
+			if err := resolve(ref); err != nil {
+				return 0, err
+			}
+		}
+		v, ok := symbolTable[symbol]
+		if !ok {
+			return 0, fmt.Errorf("undefined symbol %q", symbol)
+		}
+		return int(v), nil
+	}
+	resolve = func(c *constant) error {
+		if _, ok := symbolTable[c.Name]; ok {
+			return nil
+		}
+		if resolving[c.Name] {
+			return errorf(c.Pos, "failed to resolve constant %q: constant is defined in terms of itself", c.Name)
+		}
+		resolving[c.Name] = true
+
+		v, err := evalConstant(operandExpr(&aInstruction{Literal: c.Literal, IsSymbol: c.IsSymbol, Value: c.Value, Expr: c.Expr}), lookup)
+		if err != nil {
+			return errorf(c.Pos, "failed to resolve constant %q: %v", c.Name, err)
+		}
+		symbolTable[c.Name] = v
+		return nil
+	}
+
+	for _, c := range constants {
+		if err := resolve(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseConstant(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want instruction
+	}{
+		"ConstantValue": {
+			in: `.equ ROWS 256`,
+			want: &constant{
+				Name:    "ROWS",
+				Literal: "256",
+				Value:   256,
+			},
+		},
+		"SymbolicValue": {
+			in: `.set	VIDEO, SCREEN`,
+			want: &constant{
+				Name:     "VIDEO",
+				Literal:  "SCREEN",
+				IsSymbol: true,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseConstant(tc.in)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "parseConstant", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingName": {
+			in: `.equ`,
+		},
+		"RejectMissingValue": {
+			in: `.equ ROWS`,
+		},
+		"RejectNameWithLeadingDigit": {
+			in: `.equ 2ROWS 2`,
+		},
+		"RejectLocalName": {
+			in: `.equ .rows 2`,
+		},
+		"RejectValueExceeding15Bits": {
+			in: `.equ ROWS 32768`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseConstant(tc.in)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleConstants(t *testing.T) {
+	in := `
+.equ ROWS 256
+.equ LAST_ROW ROWS
+.equ VIDEO SCREEN
+.equ START MAIN
+(MAIN)
+	@LAST_ROW
+	D=A
+	@VIDEO
+	@START
+	@counter
+`
+	want := `0000000100000000
+1110110000010000
+0100000000000000
+0000000000000000
+0000000000010000
+`
+	var got bytes.Buffer
+	err := Assemble(strings.NewReader(in), &got)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Assemble", in, got.String(), want)
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectRedeclaration": {
+			in: `
+.equ ROWS 256
+.equ ROWS 256
+`,
+		},
+		"RejectClashWithLabel": {
+			in: `
+(ROWS)
+.equ ROWS 256
+`,
+		},
+		"RejectClashWithLaterLabel": {
+			in: `
+.equ ROWS 256
+(ROWS)
+`,
+		},
+		"RejectClashWithPredefinedSymbol": {
+			in: `.equ SCREEN 256`,
+		},
+		"RejectUndefinedSymbol": {
+			in: `.equ ROWS COLUMNS`,
+		},
+		"RejectCycle": {
+			in: `
+.equ ROWS COLUMNS
+.equ COLUMNS ROWS
+`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			err := Assemble(strings.NewReader(tc.in), new(bytes.Buffer))
+			assertError(t, err)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// block is an open structured control-flow block started by .if or .while.
+type block struct {
+	Directive string
+	ID        int
+	// Jump is the A-instruction loading the target of the conditional jump taken if the condition
+	// of the block does not hold.
+	Jump    *aInstruction
+	HasElse bool
+	Pos     pos
+}
+
+// controlFlow compiles the structured control-flow directives
+//
+//	.if COND ... .else ... .endif
+//	.while COND ... .endw
+//
+// into generated labels and conditional jumps. Blocks can be nested. The generated labels are named
+// after the kind of block and a counter like IF_1_ELSE, IF_1_END, WHILE_2 or WHILE_2_END.
+type controlFlow struct {
+	open  []*block
+	count int
+	// labels holds every label declared so far to detect labels of the user that clash with
+	// generated labels.
+	labels map[string]*label
+}
+
+// isControlFlow returns true if d is a structured control-flow directive.
+func isControlFlow(d string) bool {
+	switch d {
+	case ".if", ".else", ".endif", ".while", ".endw":
+		return true
+	}
+	return false
+}
+
+// parse compiles the control-flow directive in command found at position p.
+func (c *controlFlow) parse(command string, p pos) ([]instruction, error) {
+	d, condition := cutField(command)
+	switch d {
+	case ".if":
+		comp, jump, err := parseCondition(condition)
+		if err != nil {
+			return nil, err
+		}
+		c.count++
+		b := &block{Directive: d, ID: c.count, Pos: p}
+		b.Jump = &aInstruction{Literal: b.label("ELSE"), IsSymbol: true}
+		c.open = append(c.open, b)
+		return []instruction{
+			b.Jump,
+			&cInstruction{Comp: comp, Jump: negatedJumps[jump]},
+		}, nil
+	case ".else":
+		b, err := c.top(d, ".if")
+		if err != nil {
+			return nil, err
+		}
+		if b.HasElse {
+			return nil, fmt.Errorf("failed to parse .else: .if at %s already has an .else", b.Pos)
+		}
+		b.HasElse = true
+		return []instruction{
+			&aInstruction{Literal: b.label("END"), IsSymbol: true},
+			&cInstruction{Comp: "0", Jump: "JMP"},
+			&label{Literal: b.label("ELSE"), Generated: true},
+		}, nil
+	case ".endif":
+		b, err := c.top(d, ".if")
+		if err != nil {
+			return nil, err
+		}
+		c.open = c.open[:len(c.open)-1]
+		if !b.HasElse {
+			b.Jump.Literal = b.label("END")
+		}
+		return []instruction{&label{Literal: b.label("END"), Generated: true}}, nil
+	case ".while":
+		comp, jump, err := parseCondition(condition)
+		if err != nil {
+			return nil, err
+		}
+		c.count++
+		b := &block{Directive: d, ID: c.count, Pos: p}
+		c.open = append(c.open, b)
+		return []instruction{
+			&label{Literal: b.label(""), Generated: true},
+			&aInstruction{Literal: b.label("END"), IsSymbol: true},
+			&cInstruction{Comp: comp, Jump: negatedJumps[jump]},
+		}, nil
+	case ".endw":
+		b, err := c.top(d, ".while")
+		if err != nil {
+			return nil, err
+		}
+		c.open = c.open[:len(c.open)-1]
+		return []instruction{
+			&aInstruction{Literal: b.label(""), IsSymbol: true},
+			&cInstruction{Comp: "0", Jump: "JMP"},
+			&label{Literal: b.label("END"), Generated: true},
+		}, nil
+	}
+	return nil, fmt.Errorf("failed to parse directive %q: unknown control-flow directive", d)
+}
+
+// declare records the label l and returns an error if a label declared by the user has the name of
+// a generated label.
+func (c *controlFlow) declare(l *label) error {
+	prev, ok := c.labels[l.Literal]
+	if !ok {
+		if c.labels == nil {
+			c.labels = make(map[string]*label)
+		}
+		c.labels[l.Literal] = l
+		return nil
+	}
+	if l.Generated && !prev.Generated {
+		return errorf(l.Pos, "failed to parse control-flow directive: generated label %q clashes with the label declared at %s, rename the label", l.Literal, prev.Pos)
+	}
+	if !l.Generated && prev.Generated {
+		return errorf(l.Pos, "failed to parse label %q: label clashes with the label generated by the control-flow directive at %s, rename the label", l.Literal, prev.Pos)
+	}
+	// labels re-declared by the user are reported when symbols are declared
+	return nil
+}
+
+// top returns the innermost open block which needs to be started by given directive so that it
+// can be continued or closed by directive d.
+func (c *controlFlow) top(d, start string) (*block, error) {
+	if len(c.open) == 0 {
+		return nil, fmt.Errorf("failed to parse %s: missing %s", d, start)
+	}
+	b := c.open[len(c.open)-1]
+	if b.Directive != start {
+		return nil, fmt.Errorf("failed to parse %s: innermost block is a %s at %s", d, b.Directive, b.Pos)
+	}
+	return b, nil
+}
+
+// close returns an error if any block has not been closed.
+func (c *controlFlow) close() error {
+	if len(c.open) == 0 {
+		return nil
+	}
+	b := c.open[len(c.open)-1]
+	end := ".endif"
+	if b.Directive == ".while" {
+		end = ".endw"
+	}
+	return errorf(b.Pos, "failed to parse %s: missing %s", b.Directive, end)
+}
+
+// label returns the name of the generated label with given suffix.
+func (b *block) label(suffix string) string {
+	name := strings.ToUpper(b.Directive[1:]) + "_" + strconv.Itoa(b.ID)
+	if suffix == "" {
+		return name
+	}
+	return name + "_" + suffix
+}
+
+// relations maps the relational operators of a condition to the jump taken if the condition holds.
+var relations = map[string]string{
+	">":  "JGT",
+	"=":  "JEQ",
+	"==": "JEQ",
+	">=": "JGE",
+	"<":  "JLT",
+	"!=": "JNE",
+	"<>": "JNE",
+	"<=": "JLE",
+}
+
+// negatedJumps maps a jump to the jump that is taken if the condition of the jump does not hold.
+var negatedJumps = map[string]string{
+	"JGT": "JLE",
+	"JEQ": "JNE",
+	"JGE": "JLT",
+	"JLT": "JGE",
+	"JNE": "JEQ",
+	"JLE": "JGT",
+}
+
+// parseCondition parses a condition like D>0 or D-1 != 0 into the comp and the jump which is taken
+// if the condition holds. The computation cannot read the A register or memory as A is needed to
+// hold the target of the jump.
+func parseCondition(in string) (comp, jump string, err error) {
+	in = strings.Join(strings.Fields(in), "")
+	if in == "" {
+		return "", "", errors.New("missing condition like D>0")
+	}
+	start := strings.IndexAny(in, "<>=!")
+	if start < 0 {
+		return "", "", fmt.Errorf("condition %q is missing a relational operator like > or !=", in)
+	}
+	end := start
+	for end < len(in) && strings.ContainsRune("<>=!", rune(in[end])) {
+		end++
+	}
+	comp, op, value := in[:start], in[start:end], in[end:]
+
+	jump, ok := relations[op]
+	if !ok {
+		return "", "", fmt.Errorf("condition %q has invalid relational operator %q", in, op)
+	}
+	if value != "0" {
+		return "", "", fmt.Errorf("condition %q needs to compare against 0", in)
+	}
+	if _, ok := compToC[comp]; !ok {
+		return "", "", fmt.Errorf("condition %q has invalid computation %q", in, comp)
+	}
+	if strings.ContainsAny(comp, "AM") {
+		return "", "", fmt.Errorf("condition %q cannot read A or M as A holds the jump target. Load the value into D first", in)
+	}
+	return comp, jump, nil
+}
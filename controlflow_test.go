@@ -0,0 +1,153 @@
+package hack
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseControlFlow(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want []string
+	}{
+		"If": {
+			in: `
+.if D>0
+	M=D
+.endif
+`,
+			want: []string{"@IF_1_END", "D;JLE", "M=D", "(IF_1_END)"},
+		},
+		"IfElse": {
+			in: `
+.if D != 0
+	M=1
+.else
+	M=0
+.endif
+`,
+			want: []string{"@IF_1_ELSE", "D;JEQ", "M=1", "@IF_1_END", "0;JMP", "(IF_1_ELSE)", "M=0", "(IF_1_END)"},
+		},
+		"While": {
+			in: `
+.while D-1>=0
+	D=D-1
+.endw
+`,
+			want: []string{"(WHILE_1)", "@WHILE_1_END", "D-1;JLT", "D=D-1", "@WHILE_1", "0;JMP", "(WHILE_1_END)"},
+		},
+		"Nested": {
+			in: `
+.while D>0
+	.if D<0
+		D=0
+	.endif
+.endw
+`,
+			want: []string{
+				"(WHILE_1)", "@WHILE_1_END", "D;JLE",
+				"@IF_2_END", "D;JGE", "D=0", "(IF_2_END)",
+				"@WHILE_1", "0;JMP", "(WHILE_1_END)",
+			},
+		},
+		"GeneratedLabelsDoNotChangeScopeOfLocalLabels": {
+			in: `
+(MAIN)
+.if D=0
+	@.done
+.endif
+(.done)
+`,
+			want: []string{"(MAIN)", "@IF_1_END", "D;JNE", "@MAIN.done", "(IF_1_END)", "(MAIN.done)"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			instructions, err := new(Assembler).parse(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			var got []string
+			for _, ins := range instructions {
+				got = append(got, ins.(fmt.Stringer).String())
+			}
+			assertDeepEquals(t, "parse", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingCondition": {
+			in: ".if\n.endif",
+		},
+		"RejectConditionNotComparingAgainstZero": {
+			in: ".if D>1\n.endif",
+		},
+		"RejectConditionReadingMemory": {
+			in: ".if M>0\n.endif",
+		},
+		"RejectInvalidRelationalOperator": {
+			in: ".if D=>0\n.endif",
+		},
+		"RejectMissingEndif": {
+			in: ".if D>0",
+		},
+		"RejectEndifWithoutIf": {
+			in: ".endif",
+		},
+		"RejectDuplicateElse": {
+			in: ".if D>0\n.else\n.else\n.endif",
+		},
+		"RejectElseInWhile": {
+			in: ".while D>0\n.else\n.endw",
+		},
+		"RejectEndwClosingIf": {
+			in: ".while D>0\n.if D>0\n.endw\n.endif",
+		},
+		"RejectLabelDeclaredBeforeClashingWithGeneratedLabel": {
+			in: "(IF_1_END)\n.if D>0\n.endif",
+		},
+		"RejectLabelDeclaredAfterClashingWithGeneratedLabel": {
+			in: ".while D>0\n.endw\n(WHILE_1)",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := new(Assembler).parse(strings.NewReader(tc.in), "")
+			assertError(t, err)
+		})
+	}
+}
+
+func TestParseControlFlowReportsClashingLabels(t *testing.T) {
+	in := "(IF_1_END)\n.if D>0\n\tD=D-1\n.endif"
+	_, err := new(Assembler).parse(strings.NewReader(in), "")
+	assertError(t, err)
+
+	want := `line 4: failed to parse control-flow directive: generated label "IF_1_END" clashes with the label declared at line 1, rename the label`
+	assertDeepEquals(t, "parse", in, err.Error(), want)
+}
+
+func TestAssembleControlFlowSymbols(t *testing.T) {
+	in := `
+	@R0
+	D=M
+.while D>0
+	D=D-1
+.endw
+`
+	var symbols bytes.Buffer
+	asm := Assembler{Symbols: &symbols}
+
+	err := asm.Assemble(strings.NewReader(in), new(bytes.Buffer))
+	assertNoError(t, err)
+
+	want := `WHILE_1      2  label
+WHILE_1_END  7  label
+`
+	assertDeepEquals(t, "Assemble", in, symbols.String(), want)
+}
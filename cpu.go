@@ -0,0 +1,141 @@
+package hack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ramSize is the number of words of RAM addressable by the hack computer including the screen and
+// keyboard memory maps.
+const ramSize = 1 << 15
+
+// cpu executes hack machine code as described in https://www.nand2tetris.org/project05.
+type cpu struct {
+	ROM []uint16
+	RAM [ramSize]uint16
+	A   uint16
+	D   uint16
+	PC  uint16
+	// Cycles is the number of instructions executed.
+	Cycles int
+}
+
+// readProgram reads machine code written by Assemble.
+func readProgram(r io.Reader) ([]uint16, error) {
+	var rom []uint16
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(text, 2, 16)
+		if err != nil || len(text) != 16 {
+			return nil, fmt.Errorf("line %d: expected 16 bits of machine code instead got %q", n, text)
+		}
+		rom = append(rom, uint16(v))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rom, nil
+}
+
+// jmp is the machine code of the unconditional jump 0;JMP.
+const jmp = 0b1110101010000111
+
+// halted returns true if the program counter is past the end of the program or if the program
+// entered the infinite loop
+//
+//	(END)
+//		@END
+//		0;JMP
+//
+// that is used to end hack programs.
+func (c *cpu) halted() bool {
+	if int(c.PC) >= len(c.ROM) {
+		return true
+	}
+	return c.PC > 0 && c.ROM[c.PC] == jmp && c.A == c.PC-1 && c.ROM[c.PC-1] == c.PC-1
+}
+
+// run executes instructions until the program halts or the maximum number of cycles is reached. It
+// returns false if the program did not halt.
+func (c *cpu) run(maxCycles int) bool {
+	for !c.halted() {
+		if c.Cycles >= maxCycles {
+			return false
+		}
+		c.step()
+	}
+	return true
+}
+
+// step executes the instruction at the program counter.
+func (c *cpu) step() {
+	instruction := c.ROM[c.PC]
+	c.Cycles++
+	if instruction&0x8000 == 0 {
+		c.A = instruction
+		c.PC++
+		return
+	}
+
+	y := c.A
+	if instruction&0x1000 != 0 {
+		y = c.RAM[c.A&(ramSize-1)]
+	}
+	out := alu(c.D, y, instruction>>6&0x3f)
+
+	address := c.A & (ramSize - 1)
+	if instruction&0x20 != 0 {
+		c.A = out
+	}
+	if instruction&0x10 != 0 {
+		c.D = out
+	}
+	if instruction&0x08 != 0 {
+		c.RAM[address] = out
+	}
+
+	if jumps(int16(out), instruction&0x7) {
+		c.PC = c.A
+	} else {
+		c.PC++
+	}
+}
+
+// alu computes the output of the hack ALU for inputs x and y given the control bits zx nx zy ny f no
+// from most to least significant bit.
+func alu(x, y, control uint16) uint16 {
+	if control&0x20 != 0 {
+		x = 0
+	}
+	if control&0x10 != 0 {
+		x = ^x
+	}
+	if control&0x08 != 0 {
+		y = 0
+	}
+	if control&0x04 != 0 {
+		y = ^y
+	}
+	var out uint16
+	if control&0x02 != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if control&0x01 != 0 {
+		out = ^out
+	}
+	return out
+}
+
+// jumps returns true if the jump bits j1 j2 j3 select a jump for given ALU output.
+func jumps(out int16, jump uint16) bool {
+	return (jump&0x4 != 0 && out < 0) || (jump&0x2 != 0 && out == 0) || (jump&0x1 != 0 && out > 0)
+}
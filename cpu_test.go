@@ -0,0 +1,124 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCPU(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want map[uint16]uint16
+	}{
+		"Add": {
+			in: `
+	@2
+	D=A
+	@3
+	D=D+A
+	@R0
+	M=D
+`,
+			want: map[uint16]uint16{0: 5},
+		},
+		"Max": {
+			in: `
+	@R0
+	D=M
+	@R1
+	D=D-M
+	@FIRST
+	D;JGT
+	@R1
+	D=M
+	@STORE
+	0;JMP
+(FIRST)
+	@R0
+	D=M
+(STORE)
+	@R2
+	M=D
+`,
+			want: map[uint16]uint16{2: 7},
+		},
+		"Loop": {
+			in: `
+	@10
+	D=A
+	@R0
+	M=D
+(LOOP)
+	@R1
+	M=M+1
+	@R0
+	MD=M-1
+	@LOOP
+	D;JGT
+`,
+			want: map[uint16]uint16{0: 0, 1: 13},
+		},
+		"NegativeAndBitwise": {
+			in: `
+	@-6
+	D=A
+	@0x0F0F
+	D=D&A
+	@R0
+	M=!D
+`,
+			want: map[uint16]uint16{0: 0xF0F5},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := load(t, tc.in)
+			c.RAM[0], c.RAM[1] = 7, 3
+			if !c.run(1000) {
+				t.Fatalf("run(%q) did not halt", tc.in)
+			}
+
+			for address, want := range tc.want {
+				if got := c.RAM[address]; got != want {
+					t.Errorf("run(%q) RAM[%d] = %d; want %d", tc.in, address, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("HaltsInEndLoop", func(t *testing.T) {
+		c := load(t, "\tD=0\n(END)\n\t@END\n\t0;JMP\n\tD=1")
+		if !c.run(100) {
+			t.Errorf("run() did not halt")
+		}
+		assertEquals(t, "run", "", uint16(2), c.PC)
+	})
+
+	t.Run("StopsAfterMaxCycles", func(t *testing.T) {
+		c := load(t, "(LOOP)\n\tD=D+1\n\t@LOOP\n\t0;JMP")
+		if c.run(100) {
+			t.Errorf("run() halted; want it to stop after 100 cycles")
+		}
+		assertEquals(t, "run", "", 100, c.Cycles)
+	})
+
+	t.Run("RejectInvalidMachineCode", func(t *testing.T) {
+		_, err := readProgram(strings.NewReader("0101"))
+		assertError(t, err)
+	})
+}
+
+// load assembles the hack assembly in and loads it into a cpu.
+func load(t *testing.T, in string) *cpu {
+	t.Helper()
+
+	var machine bytes.Buffer
+	err := Assemble(strings.NewReader(in), &machine)
+	assertNoError(t, err)
+
+	rom, err := readProgram(&machine)
+	assertNoError(t, err)
+	return &cpu{ROM: rom}
+}
@@ -0,0 +1,337 @@
+package hack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// reachable returns for every instruction whether it can be executed. Execution starts at ROM
+// address 0, at every label whose address is loaded by a reachable A-instruction, directly or
+// through a constant defined as the label, and at every ROM address loaded as number by a reachable
+// A-instruction that might be jumped to, see mayJumpTo. Execution continues with the next
+// instruction unless the instruction jumps unconditionally. ok is false if the program computes ROM
+// addresses from labels like @LOOP+2, also in constants, as the instructions such an address refers
+// to are not known.
+func reachable(instructions []instruction) (reached []bool, ok bool) {
+	if _, found := labelArithmetic(instructions); found {
+		return nil, false
+	}
+
+	labels := make(map[string]int)
+	addresses := make(map[int]int)
+	pcs, _ := romAddresses(instructions)
+	for i, ins := range instructions {
+		if l, ok := ins.(*label); ok {
+			labels[l.Literal] = i
+		}
+		if isCode(ins) {
+			addresses[pcs[i]] = i
+		}
+	}
+
+	// labels are also loaded through constants defined as labels
+	aliases := labelAliases(instructions)
+	_, indirect := indirectJump(instructions)
+	reached = make([]bool, len(instructions))
+	starts := []int{0}
+	for len(starts) > 0 {
+		i := starts[len(starts)-1]
+		starts = starts[:len(starts)-1]
+
+	flow:
+		for ; i < len(instructions) && !reached[i]; i++ {
+			reached[i] = true
+			if value, ok := mayJumpTo(instructions, i, indirect); ok {
+				if start, ok := addresses[int(value)]; ok {
+					starts = append(starts, start)
+				}
+			}
+			switch ins := instructions[i].(type) {
+			case *aInstruction:
+				mapSymbols(operandExpr(ins), func(symbol string) string {
+					if start, ok := labels[aliases[symbol]]; ok && !reached[start] {
+						starts = append(starts, start)
+					}
+					return symbol
+				})
+			case *cInstruction, *word:
+				if c, ok := asCInstruction(ins); ok && alwaysJumps(c) {
+					break flow
+				}
+			}
+		}
+	}
+	return reached, true
+}
+
+// romAddresses returns the ROM address of every instruction and the size of the program.
+// Instructions that are not translated into machine code share the address of the next instruction.
+func romAddresses(instructions []instruction) ([]int, int) {
+	addresses := make([]int, len(instructions))
+	var pc int
+	for i, ins := range instructions {
+		if p, ok := ins.(*placement); ok {
+			padding, _ := p.padding(pc)
+			pc += padding
+		}
+		addresses[i] = pc
+		if isCode(ins) {
+			pc++
+		}
+	}
+	return addresses, pc
+}
+
+// numericLoad returns the number loaded into A by an A-instruction given as number like @95. Linked
+// objects hold such A-instructions as words.
+func numericLoad(ins instruction) (uint16, bool) {
+	switch ins := ins.(type) {
+	case *aInstruction:
+		return ins.Value, !ins.IsSymbol && ins.Expr == nil
+	case *word:
+		return ins.Code, ins.Code&0x8000 == 0
+	}
+	return 0, false
+}
+
+// isLoad returns true if the instruction loads a value into A, also if it is the word of a linked
+// object.
+func isLoad(ins instruction) bool {
+	if _, ok := ins.(*aInstruction); ok {
+		return true
+	}
+	_, ok := numericLoad(ins)
+	return ok
+}
+
+// asCInstruction returns the instruction as C-instruction decoding the words of linked objects.
+func asCInstruction(ins instruction) (*cInstruction, bool) {
+	switch ins := ins.(type) {
+	case *cInstruction:
+		return ins, true
+	case *word:
+		if ins.Code&0x8000 != 0 {
+			return decodeCInstruction(ins.Code, ins.Pos)
+		}
+	}
+	return nil, false
+}
+
+// decodeCInstruction decodes the machine code of a C-instruction. ok is false if the comp bits do
+// not encode a computation of the hack ALU.
+func decodeCInstruction(code uint16, p pos) (c *cInstruction, ok bool) {
+	bits := fmt.Sprintf("%016b", code)
+	c = &cInstruction{Pos: p}
+	for comp, cBits := range compToC {
+		if compToA[comp] == bits[3:4] && cBits == bits[4:10] {
+			c.Comp = comp
+		}
+	}
+	for dest, dBits := range destToD {
+		if dBits == bits[10:13] {
+			c.Dest = dest
+		}
+	}
+	for jump, jBits := range jumpToJ {
+		if jBits == bits[13:16] {
+			c.Jump = jump
+		}
+	}
+	return c, c.Comp != ""
+}
+
+// isAbsoluteJump returns true if the instruction at index i loads a number into A that the next
+// instruction jumps to.
+func isAbsoluteJump(instructions []instruction, i int) bool {
+	if _, ok := numericLoad(instructions[i]); !ok || i+1 >= len(instructions) {
+		return false
+	}
+	c, ok := asCInstruction(instructions[i+1])
+	return ok && c.Jump != ""
+}
+
+// absoluteJump returns the position of the first jump to a ROM address given as number.
+func absoluteJump(instructions []instruction) (pos, bool) {
+	for i, ins := range instructions {
+		if isAbsoluteJump(instructions, i) {
+			return codePos(ins), true
+		}
+	}
+	return pos{}, false
+}
+
+// isIndirectJump returns true if the instruction at index i jumps to an address that is not loaded
+// by the A-instruction right before it like @R13 A=M 0;JMP. The address might have been stored as a
+// number.
+func isIndirectJump(instructions []instruction, i int) bool {
+	c, ok := asCInstruction(instructions[i])
+	if !ok || c.Jump == "" {
+		return false
+	}
+	return strings.Contains(c.Dest, "A") || i == 0 || !isLoad(instructions[i-1])
+}
+
+// indirectJump returns the position of the first indirect jump.
+func indirectJump(instructions []instruction) (pos, bool) {
+	for i, ins := range instructions {
+		if isIndirectJump(instructions, i) {
+			return codePos(ins), true
+		}
+	}
+	return pos{}, false
+}
+
+// mayJumpTo returns the number loaded by the A-instruction at index i if it might be a ROM address
+// that is jumped to. That is the case if the next instruction jumps like @95 0;JMP or, if the
+// program jumps indirectly, computes a value from A like @95 D=A as the value might be stored and
+// jumped to later.
+func mayJumpTo(instructions []instruction, i int, indirect bool) (uint16, bool) {
+	value, ok := numericLoad(instructions[i])
+	if !ok || i+1 >= len(instructions) {
+		return 0, false
+	}
+	if isAbsoluteJump(instructions, i) {
+		return value, true
+	}
+	if !indirect || strings.HasPrefix(codePos(instructions[i]).File, "std/") {
+		// the standard library does not store ROM addresses as numbers
+		return 0, false
+	}
+	next := instructions[i+1]
+	if c, ok := asCInstruction(next); ok {
+		return value, readsA(c)
+	}
+	// A still holds the number if code following a label or directive is reached from here
+	return value, !isCode(next)
+}
+
+// readsA returns true if the C-instruction computes a value from A itself rather than from the word
+// A refers to.
+func readsA(c *cInstruction) bool {
+	return compToA[c.Comp] == "0" && strings.Contains(c.Comp, "A")
+}
+
+// constantComps are the computations that do not depend on any register.
+var constantComps = map[string]int16{
+	"0":  0,
+	"1":  1,
+	"-1": -1,
+}
+
+// alwaysJumps returns true if the C-instruction jumps no matter the values of its registers like
+// 0;JMP or 0;JEQ.
+func alwaysJumps(c *cInstruction) bool {
+	if c.Jump == "JMP" {
+		return true
+	}
+	out, ok := constantComps[c.Comp]
+	if !ok {
+		return false
+	}
+	bits, err := strconv.ParseUint(jumpToJ[c.Jump], 2, 16)
+	return err == nil && jumps(out, uint16(bits))
+}
+
+// eliminateDeadCode warns about every sequence of instructions that cannot be executed and removes
+// them if a.DropUnreachable is set. Code is not removed from programs jumping to ROM addresses given
+// as numbers nor before a number an indirect jump might go to as removing instructions would move
+// the code they refer to. Labels are kept so they refer to the instruction following the removed
+// code once they are resolved.
+func (a *Assembler) eliminateDeadCode(instructions []instruction) []instruction {
+	reached, ok := reachable(instructions)
+	if !ok {
+		if a.DropUnreachable {
+			p, _ := labelArithmetic(instructions)
+			a.warnf(p, warnUnreachable, "unreachable code is not removed as the program computes a ROM address from a label")
+		}
+		return instructions
+	}
+	drop := a.DropUnreachable
+	if p, ok := absoluteJump(instructions); ok && drop {
+		a.warnf(p, warnUnreachable, "unreachable code is not removed as the program jumps to a ROM address given as number")
+		drop = false
+	}
+	addresses := make([]int, len(instructions))
+	pinned, pinnedAt := -1, pos{}
+	if drop {
+		var size int
+		addresses, size = romAddresses(instructions)
+		pinned, pinnedAt = pinnedAddress(instructions, reached, size)
+	}
+
+	var kept []instruction
+	for i := 0; i < len(instructions); i++ {
+		if reached[i] || !isCode(instructions[i]) {
+			kept = append(kept, instructions[i])
+			continue
+		}
+
+		first, n := instructions[i], 0
+		remove := drop && addresses[i] > pinned
+		if drop && !remove && pinnedAt != (pos{}) {
+			a.warnf(pinnedAt, warnUnreachable, "unreachable code before ROM address %d is not removed as the program might jump to the number loaded here indirectly", pinned)
+			pinnedAt = pos{}
+		}
+		for ; i < len(instructions) && !reached[i]; i++ {
+			if isCode(instructions[i]) {
+				n++
+				if remove {
+					continue
+				}
+			}
+			kept = append(kept, instructions[i])
+		}
+		i--
+		if strings.HasPrefix(codePos(first).File, "std/") {
+			// routines of the standard library that are not called are expected
+			continue
+		}
+		if n == 1 {
+			a.warnf(codePos(first), warnUnreachable, "instruction %q is unreachable", first)
+		} else {
+			a.warnf(codePos(first), warnUnreachable, "%d instructions starting with %q are unreachable", n, first)
+		}
+	}
+	return kept
+}
+
+// pinnedAddress returns the highest ROM address of code that a reachable A-instruction loads as a
+// number the program might jump to together with the position of the A-instruction. Removing code
+// before it would change what the number refers to. Every instruction is considered reachable if
+// reached is nil. The address is -1 if there is none.
+func pinnedAddress(instructions []instruction, reached []bool, size int) (int, pos) {
+	_, indirect := indirectJump(instructions)
+	pinned, at := -1, pos{}
+	for i := range instructions {
+		if reached != nil && !reached[i] {
+			continue
+		}
+		if value, ok := mayJumpTo(instructions, i, indirect); ok && int(value) < size && int(value) > pinned {
+			pinned, at = int(value), codePos(instructions[i])
+		}
+	}
+	return pinned, at
+}
+
+// isCode returns true if the instruction is translated into machine code.
+func isCode(ins instruction) bool {
+	switch ins.(type) {
+	case *aInstruction, *cInstruction, *word:
+		return true
+	}
+	return false
+}
+
+// codePos returns the position of an instruction that is translated into machine code.
+func codePos(ins instruction) pos {
+	switch ins := ins.(type) {
+	case *aInstruction:
+		return ins.Pos
+	case *cInstruction:
+		return ins.Pos
+	case *word:
+		return ins.Pos
+	}
+	return pos{}
+}
@@ -0,0 +1,317 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := map[string]struct {
+		in       string
+		want     string
+		warnings string
+	}{
+		"CodeAfterUnconditionalJump": {
+			in: `
+	@END
+	0;JMP
+	D=1
+	@R0
+	M=D
+(END)
+	@END
+	0;JMP
+`,
+			want: `
+	@END
+	0;JMP
+(END)
+	@END
+	0;JMP
+`,
+			warnings: "line 4: warning: 3 instructions starting with \"D=1\" are unreachable [unreachable-code]\n",
+		},
+		"CodeAfterConstantJump": {
+			in: `
+	@END
+	0;JEQ
+	D=1
+(END)
+`,
+			want: `
+	@END
+	0;JEQ
+(END)
+`,
+			warnings: "line 4: warning: instruction \"D=1\" is unreachable [unreachable-code]\n",
+		},
+		"CodeAfterConditionalJump": {
+			in: `
+	@END
+	D;JEQ
+	D=1
+(END)
+`,
+			want: `
+	@END
+	D;JEQ
+	D=1
+(END)
+`,
+		},
+		"CodeAtLabelLoadedIntoA": {
+			in: `
+	@RET
+	D=A
+	@R15
+	M=D
+	@FUNC
+	0;JMP
+(RET)
+	@RET
+	0;JMP
+(FUNC)
+	@R15
+	A=M
+	0;JMP
+`,
+			want: `
+	@RET
+	D=A
+	@R15
+	M=D
+	@FUNC
+	0;JMP
+(RET)
+	@RET
+	0;JMP
+(FUNC)
+	@R15
+	A=M
+	0;JMP
+`,
+		},
+		"CodeReachedThroughConstant": {
+			in: `
+.equ ALIAS Work
+.equ HANDLER ALIAS
+	@HANDLER
+	0;JMP
+	D=1
+(Work)
+	D=0
+`,
+			warnings: "line 6: warning: instruction \"D=1\" is unreachable [unreachable-code]\n",
+			want: `
+.equ ALIAS Work
+.equ HANDLER ALIAS
+	@HANDLER
+	0;JMP
+(Work)
+	D=0
+`,
+		},
+		"KeepCodeIfComputingAddressesFromLabelsInConstants": {
+			in: `
+.equ NEXT Work+1
+	@NEXT
+	0;JMP
+(Work)
+	D=0
+	D=1
+`,
+			warnings: "line 2: warning: unreachable code is not removed as the program computes a ROM address from a label [unreachable-code]\n",
+		},
+		"CodeOnlyReachedFromUnreachableCode": {
+			in: `
+(END)
+	@END
+	0;JMP
+(DEAD)
+	@UNUSED
+	0;JMP
+(UNUSED)
+	D=0
+`,
+			want: `
+(END)
+	@END
+	0;JMP
+(DEAD)
+(UNUSED)
+`,
+			warnings: "line 5: warning: label \"DEAD\" is never referenced [unused-label]\n" +
+				"line 6: warning: 3 instructions starting with \"@UNUSED\" are unreachable [unreachable-code]\n",
+		},
+		"LabelsAreResolvedAfterRemovingCode": {
+			in: `
+	@MAIN
+	0;JMP
+	D=0
+	D=1
+(MAIN)
+	@MAIN
+	0;JMP
+`,
+			want: `
+	@MAIN
+	0;JMP
+(MAIN)
+	@MAIN
+	0;JMP
+`,
+			warnings: "line 4: warning: 2 instructions starting with \"D=0\" are unreachable [unreachable-code]\n",
+		},
+		"KeepCodeIfJumpingToNumbers": {
+			in: `
+	@3
+	0;JMP
+	D=0
+	D=1
+	@4
+	0;JMP
+`,
+			want: `
+	@3
+	0;JMP
+	D=0
+	D=1
+	@4
+	0;JMP
+`,
+			warnings: "line 2: warning: unreachable code is not removed as the program jumps to a ROM address given as number [unreachable-code]\n" +
+				"line 4: warning: instruction \"D=0\" is unreachable [unreachable-code]\n",
+		},
+		"CodeReachedThroughROMAddressStoredInRAM": {
+			in: `
+	@7
+	D=A
+	@R13
+	M=D
+	@R13
+	A=M
+	0;JMP
+	@R0
+	M=1
+(END)
+	@END
+	0;JMP
+	D=0
+`,
+			want: `
+	@7
+	D=A
+	@R13
+	M=D
+	@R13
+	A=M
+	0;JMP
+	@R0
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+			warnings: "line 14: warning: instruction \"D=0\" is unreachable [unreachable-code]\n",
+		},
+		"KeepCodeBeforeROMAddressStoredInRAM": {
+			in: `
+	@9
+	D=A
+	@R13
+	M=D
+	@R13
+	A=M
+	0;JMP
+	D=0
+	D=1
+	@R0
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+			want: `
+	@9
+	D=A
+	@R13
+	M=D
+	@R13
+	A=M
+	0;JMP
+	D=0
+	D=1
+	@R0
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+			warnings: "line 2: warning: unreachable code before ROM address 9 is not removed as the program might jump to the number loaded here indirectly [unreachable-code]\n" +
+				"line 9: warning: 2 instructions starting with \"D=0\" are unreachable [unreachable-code]\n",
+		},
+		"KeepCodeIfComputingAddressesFromLabels": {
+			in: `
+	@LOOP+1
+	0;JMP
+(LOOP)
+	D=0
+	D=1
+`,
+			want: `
+	@LOOP+1
+	0;JMP
+(LOOP)
+	D=0
+	D=1
+`,
+			warnings: "line 2: warning: unreachable code is not removed as the program computes a ROM address from a label [unreachable-code]\n",
+		},
+		"IgnoreRoutinesOfTheStandardLibraryThatAreNotCalled": {
+			in: `
+	@RET
+	D=A
+	@std.ret
+	M=D
+	@std.div
+	0;JMP
+(RET)
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got bytes.Buffer
+			var warnings strings.Builder
+			asm := Assembler{DropUnreachable: true, Warnings: &warnings}
+			err := asm.Assemble(strings.NewReader(tc.in), &got)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", tc.in, warnings.String(), tc.warnings)
+			if tc.want == "" {
+				return
+			}
+			var want bytes.Buffer
+			err = Assemble(strings.NewReader(tc.want), &want)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", tc.in, got.String(), want.String())
+		})
+	}
+
+	t.Run("OnlyWarnByDefault", func(t *testing.T) {
+		in := "\t@END\n\t0;JMP\n\tD=1\n(END)\n"
+		var got, want bytes.Buffer
+		var warnings strings.Builder
+		asm := Assembler{Warnings: &warnings}
+		err := asm.Assemble(strings.NewReader(in), &got)
+		assertNoError(t, err)
+
+		err = new(Assembler).Assemble(strings.NewReader(in), &want)
+		assertNoError(t, err)
+		assertDeepEquals(t, "Assemble", in, got.String(), want.String())
+		assertDeepEquals(t, "Assemble", in, warnings.String(), "line 3: warning: instruction \"D=1\" is unreachable [unreachable-code]\n")
+	})
+}
@@ -0,0 +1,111 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// entry represents the entry point of a program declared using .entry LABEL. It is a
+// pseudo-instruction that is replaced by a prologue at ROM address 0 that jumps to the label. Init
+// holds assignments like SP=256 of registers or variables that the prologue makes before jumping.
+type entry struct {
+	Label string
+	Init  []string
+	Pos   pos
+}
+
+func (e entry) Instruction() {}
+
+func (e entry) String() string {
+	return strings.Join(append([]string{".entry", e.Label}, e.Init...), " ")
+}
+
+// parseEntry parses an entry point declaration like .entry Main or .entry Main SP=256.
+func parseEntry(in string) (*entry, error) {
+	directive, rest := cutField(in)
+	if directive != ".entry" {
+		return nil, errors.New("failed to parse entry: entry declarations need to start with .entry")
+	}
+	label, rest := cutField(rest)
+	if !isSymbol(label) || isLocal(label) {
+		return nil, fmt.Errorf("failed to parse entry %q: label must be a symbol that does not begin with a digit or a dot", label)
+	}
+
+	e := &entry{Label: label}
+	for rest != "" {
+		var init string
+		init, rest = cutField(rest)
+		register, value, _ := strings.Cut(init, "=")
+		if !isSymbol(register) || value == "" {
+			return nil, fmt.Errorf("failed to parse entry %q: expected an assignment like SP=256, instead got %q", label, init)
+		}
+		if _, err := parseAInstruction("@" + value); err != nil {
+			return nil, fmt.Errorf("failed to parse entry %q: invalid value for %s: %v", label, register, err)
+		}
+		e.Init = append(e.Init, init)
+	}
+	return e, nil
+}
+
+// bootstrap replaces the entry point declaration in instructions by a prologue that initializes the
+// registers and jumps to the entry label. The entry point a.Entry takes precedence over any .entry
+// directive. Instructions are returned as is if there is no entry point.
+func (a *Assembler) bootstrap(instructions []instruction) ([]instruction, error) {
+	var e *entry
+	var out []instruction
+	labels := make(map[string]bool)
+	for _, ins := range instructions {
+		switch ins := ins.(type) {
+		case *entry:
+			if e != nil {
+				return nil, errorf(ins.Pos, "failed to declare entry %q: entry already declared at %s", ins.Label, e.Pos)
+			}
+			e = ins
+			continue
+		case *label:
+			labels[ins.Literal] = true
+		}
+		out = append(out, ins)
+	}
+
+	if a.Entry != "" {
+		var err error
+		e, err = parseEntry(".entry " + a.Entry)
+		if err != nil {
+			return nil, err
+		}
+		e.Pos = pos{File: "-entry"}
+	}
+	if e == nil {
+		return instructions, nil
+	}
+	if !labels[e.Label] {
+		return nil, errorf(e.Pos, "failed to declare entry %q: label is not declared", e.Label)
+	}
+
+	var commands []string
+	for _, init := range e.Init {
+		register, value, _ := strings.Cut(init, "=")
+		commands = append(commands, "@"+value, "D=A", "@"+register, "M=D")
+	}
+	commands = append(commands, "@"+e.Label, "0;JMP")
+
+	var prologue []instruction
+	for _, command := range commands {
+		parsed, err := a.parseCommand(command)
+		if err != nil {
+			return nil, errorf(e.Pos, "failed to generate prologue of entry %q: %v", e.Label, err)
+		}
+		for _, ins := range parsed {
+			switch ins := ins.(type) {
+			case *aInstruction:
+				ins.Pos = e.Pos
+			case *cInstruction:
+				ins.Pos = e.Pos
+			}
+		}
+		prologue = append(prologue, parsed...)
+	}
+	return append(prologue, out...), nil
+}
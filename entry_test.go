@@ -0,0 +1,150 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseEntry(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want instruction
+	}{
+		"Entry": {
+			in:   `.entry Main`,
+			want: &entry{Label: "Main"},
+		},
+		"EntryWithInit": {
+			in:   `.entry Main SP=256, LCL=0x200`,
+			want: &entry{Label: "Main", Init: []string{"SP=256", "LCL=0x200"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseEntry(tc.in)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "parseEntry", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingLabel": {
+			in: `.entry`,
+		},
+		"RejectLocalLabel": {
+			in: `.entry .main`,
+		},
+		"RejectInitWithoutValue": {
+			in: `.entry Main SP`,
+		},
+		"RejectInvalidValue": {
+			in: `.entry Main SP=2x`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseEntry(tc.in)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleEntry(t *testing.T) {
+	tests := map[string]struct {
+		in    string
+		entry string
+		want  string
+	}{
+		"Entry": {
+			in: `
+(LIB)
+	D=M
+.entry MAIN
+(MAIN)
+	D=A
+`,
+			want: `0000000000000011
+1110101010000111
+1111110000010000
+1110110000010000
+`,
+		},
+		"EntryWithInit": {
+			in: `
+.entry MAIN SP=256
+(MAIN)
+	D=A
+`,
+			want: `0000000100000000
+1110110000010000
+0000000000000000
+1110001100001000
+0000000000000110
+1110101010000111
+1110110000010000
+`,
+		},
+		"EntryOverridesDirective": {
+			in: `
+.entry LIB
+(LIB)
+	D=M
+(MAIN)
+	D=A
+`,
+			entry: "MAIN",
+			want: `0000000000000011
+1110101010000111
+1111110000010000
+1110110000010000
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got bytes.Buffer
+			asm := Assembler{Entry: tc.entry}
+			err := asm.Assemble(strings.NewReader(tc.in), &got)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", tc.in, got.String(), tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in    string
+		entry string
+	}{
+		"RejectMultipleEntries": {
+			in: `
+.entry MAIN
+.entry MAIN
+(MAIN)
+`,
+		},
+		"RejectUndeclaredLabel": {
+			in: `.entry MAIN`,
+		},
+		"RejectInvalidEntryFlag": {
+			in: `
+(MAIN)
+`,
+			entry: "MAIN SP",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			asm := Assembler{Entry: tc.entry}
+			err := asm.Assemble(strings.NewReader(tc.in), new(strings.Builder))
+			assertError(t, err)
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package hack
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// EquivalenceChecker runs two programs from the same initial RAM states and compares regions of
+// their RAM once both halted or ran for the maximum number of cycles. RAM that is not set by an
+// initial state is 0. The zero value checks 100 random initial states of R0 to R15 and compares the
+// RAM up to and including the screen.
+type EquivalenceChecker struct {
+	// Cycles is the maximum number of cycles each program runs for. It defaults to 100000.
+	Cycles int
+	// Inputs are the addresses that are set to random values or to every combination of Values.
+	// They default to R0 to R15.
+	Inputs []AddressRange
+	// Random is the number of initial states with random values at the Inputs. It defaults to 100
+	// unless Values or States are given.
+	Random int
+	// Values are the values every combination of which is assigned to the Inputs.
+	Values []uint16
+	// States are initial states given by the user. They map an address to its initial value.
+	States []map[uint16]uint16
+	// Compare are the RAM regions that are compared. They default to the RAM up to and including the
+	// screen.
+	Compare []AddressRange
+	// Seed seeds the random initial states.
+	Seed int64
+}
+
+// AddressRange is a range of RAM addresses including both From and To.
+type AddressRange struct {
+	From, To uint16
+}
+
+// maxStates is the maximum number of initial states that combining Values can result in.
+const maxStates = 1 << 20
+
+// ParseAddressRanges parses a comma separated list of RAM addresses or ranges of addresses like
+// "R0-R15,SCREEN-24575". Addresses are numbers or pre-defined symbols.
+func ParseAddressRanges(s string) ([]AddressRange, error) {
+	var ranges []AddressRange
+	for _, field := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(field), "-")
+		if !isRange {
+			to = from
+		}
+		start, err := parseAddress(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseAddress(to)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid address range %q: %d is less than %d", field, end, start)
+		}
+		ranges = append(ranges, AddressRange{From: start, To: end})
+	}
+	return ranges, nil
+}
+
+// ReadStates reads initial RAM states from r. Every line holds one state as a list of assignments
+// like "R0=7 R1=-3 0x100=0b1010". Empty lines and comments starting with // are skipped.
+func ReadStates(r io.Reader) ([]map[uint16]uint16, error) {
+	var states []map[uint16]uint16
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		text, _, _ := strings.Cut(s.Text(), "//")
+		fields := strings.FieldsFunc(text, isArgSeparator)
+		if len(fields) == 0 {
+			continue
+		}
+		state := make(map[uint16]uint16)
+		for _, field := range fields {
+			address, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid assignment %q: expected ADDRESS=VALUE", n, field)
+			}
+			a, err := parseAddress(address)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			v, err := parseValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			state[a] = v
+		}
+		states = append(states, state)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// parseAddress parses a RAM address given as number or pre-defined symbol.
+func parseAddress(s string) (uint16, error) {
+	if v, ok := predefinedSymbols[s]; ok {
+		return v, nil
+	}
+	v, err := parseNumber(s)
+	if err != nil || v >= ramSize {
+		return 0, fmt.Errorf("invalid address %q: expected a pre-defined symbol or a number less than %d", s, ramSize)
+	}
+	return uint16(v), nil
+}
+
+// parseValue parses a 16-bit value that can be negative.
+func parseValue(s string) (uint16, error) {
+	if strings.HasPrefix(s, "-") {
+		v, err := parseNumber(s[1:])
+		if err != nil || v > 1<<15 {
+			return 0, fmt.Errorf("invalid value %q: expected a 16-bit value", s)
+		}
+		return uint16(-v), nil
+	}
+	v, err := parseNumber(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q: expected a 16-bit value", s)
+	}
+	return uint16(v), nil
+}
+
+// ErrNotEquivalent is returned by Check if the programs end in different RAM for an initial state.
+var ErrNotEquivalent = errors.New("programs are not equivalent")
+
+// Check reads the machine code of programs a and b and runs both from every initial state. It writes
+// the number of initial states that were checked to w or the first counterexample. A counterexample
+// shows the initial state, the addresses that differ and the cycles at which the programs first
+// wrote different values to one of them. Check returns ErrNotEquivalent if it found a
+// counterexample.
+func (e *EquivalenceChecker) Check(a, b io.Reader, w io.Writer) error {
+	romA, err := readProgram(a)
+	if err != nil {
+		return fmt.Errorf("failed to read program a: %v", err)
+	}
+	romB, err := readProgram(b)
+	if err != nil {
+		return fmt.Errorf("failed to read program b: %v", err)
+	}
+	states, err := e.states()
+	if err != nil {
+		return err
+	}
+	compare := e.Compare
+	if len(compare) == 0 {
+		compare = []AddressRange{{From: 0, To: predefinedSymbols["KBD"] - 1}}
+	}
+	cycles := e.Cycles
+	if cycles == 0 {
+		cycles = 100000
+	}
+
+	for _, state := range states {
+		x, y := newTrace(romA, state, compare), newTrace(romB, state, compare)
+		x.run(cycles)
+		y.run(cycles)
+
+		differences := compareRAM(&x.cpu, &y.cpu, compare)
+		if len(differences) == 0 && x.halts == y.halts {
+			continue
+		}
+		writeCounterexample(w, state, x, y, differences, cycles)
+		return ErrNotEquivalent
+	}
+	_, err = fmt.Fprintf(w, "equivalent on %d initial states\n", len(states))
+	return err
+}
+
+// states returns the initial states that are checked.
+func (e *EquivalenceChecker) states() ([]map[uint16]uint16, error) {
+	inputs := e.Inputs
+	if len(inputs) == 0 {
+		inputs = []AddressRange{{From: 0, To: 15}}
+	}
+	var addresses []uint16
+	for _, r := range inputs {
+		for a := int(r.From); a <= int(r.To); a++ {
+			addresses = append(addresses, uint16(a))
+		}
+	}
+
+	states := append([]map[uint16]uint16(nil), e.States...)
+	if len(e.Values) > 0 {
+		combinations := 1
+		for range addresses {
+			combinations *= len(e.Values)
+			if combinations > maxStates {
+				return nil, fmt.Errorf("failed to check equivalence: combining %d values over %d addresses results in more than %d initial states", len(e.Values), len(addresses), maxStates)
+			}
+		}
+		for i := 0; i < combinations; i++ {
+			state := make(map[uint16]uint16)
+			for j, n := 0, i; j < len(addresses); j, n = j+1, n/len(e.Values) {
+				state[addresses[j]] = e.Values[n%len(e.Values)]
+			}
+			states = append(states, state)
+		}
+	}
+
+	random := e.Random
+	if random == 0 && len(states) == 0 {
+		random = 100
+	}
+	rng := rand.New(rand.NewSource(e.Seed))
+	for i := 0; i < random; i++ {
+		state := make(map[uint16]uint16)
+		for _, a := range addresses {
+			state[a] = uint16(rng.Uint32())
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// trace runs a program and records every change of the value at an address that is compared.
+type trace struct {
+	cpu
+	compare []AddressRange
+	changes []change
+	halts   bool
+}
+
+// change is a write changing the value at an address.
+type change struct {
+	Cycle   int
+	Address uint16
+	Value   uint16
+}
+
+func newTrace(rom []uint16, state map[uint16]uint16, compare []AddressRange) *trace {
+	t := &trace{cpu: cpu{ROM: rom}, compare: compare}
+	for a, v := range state {
+		t.RAM[a] = v
+	}
+	return t
+}
+
+func (t *trace) run(maxCycles int) {
+	for !t.halted() {
+		if t.Cycles >= maxCycles {
+			return
+		}
+		address := t.A & (ramSize - 1)
+		before := t.RAM[address]
+		t.step()
+		if t.RAM[address] != before && contains(t.compare, address) {
+			t.changes = append(t.changes, change{Cycle: t.Cycles, Address: address, Value: t.RAM[address]})
+		}
+	}
+	t.halts = true
+}
+
+// contains returns true if address is in one of the ranges.
+func contains(ranges []AddressRange, address uint16) bool {
+	for _, r := range ranges {
+		if address >= r.From && address <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// compareRAM returns the compared addresses that hold different values.
+func compareRAM(a, b *cpu, compare []AddressRange) []uint16 {
+	var differences []uint16
+	for _, r := range compare {
+		for address := int(r.From); address <= int(r.To); address++ {
+			if a.RAM[address] != b.RAM[address] {
+				differences = append(differences, uint16(address))
+			}
+		}
+	}
+	return differences
+}
+
+// firstDivergence returns the changes of a and b at which the values written to an address first
+// differ. The changes are nil if a program did not change the address as often as the other.
+// Addresses are considered in the order of the cycle at which they diverge in a or b.
+func firstDivergence(a, b *trace) (*change, *change) {
+	history := func(changes []change) map[uint16][]*change {
+		h := make(map[uint16][]*change)
+		for i := range changes {
+			h[changes[i].Address] = append(h[changes[i].Address], &changes[i])
+		}
+		return h
+	}
+	ha, hb := history(a.changes), history(b.changes)
+
+	var firstA, firstB *change
+	cycle := func(x, y *change) int {
+		c := int(^uint(0) >> 1)
+		if x != nil {
+			c = x.Cycle
+		}
+		if y != nil && y.Cycle < c {
+			c = y.Cycle
+		}
+		return c
+	}
+	var addresses []uint16
+	for address := range ha {
+		addresses = append(addresses, address)
+	}
+	for address := range hb {
+		if _, ok := ha[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	sortAddresses(addresses)
+	for _, address := range addresses {
+		xs, ys := ha[address], hb[address]
+		for i := 0; i < len(xs) || i < len(ys); i++ {
+			var x, y *change
+			if i < len(xs) {
+				x = xs[i]
+			}
+			if i < len(ys) {
+				y = ys[i]
+			}
+			if x != nil && y != nil && x.Value == y.Value {
+				continue
+			}
+			if (firstA == nil && firstB == nil) || cycle(x, y) < cycle(firstA, firstB) {
+				firstA, firstB = x, y
+			}
+			break
+		}
+	}
+	return firstA, firstB
+}
+
+// maxDifferences is the maximum number of addresses that differ shown for a counterexample.
+const maxDifferences = 10
+
+// writeCounterexample writes the initial state, the addresses that differ and where the programs
+// diverged.
+func writeCounterexample(w io.Writer, state map[uint16]uint16, a, b *trace, differences []uint16, cycles int) {
+	addresses := make([]uint16, 0, len(state))
+	for address := range state {
+		addresses = append(addresses, address)
+	}
+	sortAddresses(addresses)
+	fmt.Fprint(w, "counterexample:")
+	for _, address := range addresses {
+		fmt.Fprintf(w, " %d=%d", address, int16(state[address]))
+	}
+	fmt.Fprintln(w)
+
+	for i, t := range []*trace{a, b} {
+		name := string(rune('a' + i))
+		if t.halts {
+			fmt.Fprintf(w, "  %s halted after %d cycles\n", name, t.Cycles)
+		} else {
+			fmt.Fprintf(w, "  %s did not halt within %d cycles\n", name, cycles)
+		}
+	}
+	for i, address := range differences {
+		if i == maxDifferences {
+			fmt.Fprintf(w, "  and %d more addresses\n", len(differences)-maxDifferences)
+			break
+		}
+		fmt.Fprintf(w, "  RAM[%d]: a %d, b %d\n", address, int16(a.RAM[address]), int16(b.RAM[address]))
+	}
+
+	x, y := firstDivergence(a, b)
+	if x == nil && y == nil {
+		return
+	}
+	describe := func(c *change) string {
+		if c == nil {
+			return "no write"
+		}
+		return fmt.Sprintf("%d at cycle %d", int16(c.Value), c.Cycle)
+	}
+	address := x
+	if address == nil {
+		address = y
+	}
+	fmt.Fprintf(w, "  first diverging write to RAM[%d]: a %s, b %s\n", address.Address, describe(x), describe(y))
+}
+
+func sortAddresses(addresses []uint16) {
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+}
@@ -0,0 +1,194 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// maxProgram stores the maximum of R0 and R1 in R2.
+const maxProgram = `
+	@R0
+	D=M
+	@R1
+	D=D-M
+	@FIRST
+	D;JGT
+	@R1
+	D=M
+	@STORE
+	0;JMP
+(FIRST)
+	@R0
+	D=M
+(STORE)
+	@R2
+	M=D
+(END)
+	@END
+	0;JMP
+`
+
+func TestCheckEquivalence(t *testing.T) {
+	tests := map[string]struct {
+		a, b    string
+		checker EquivalenceChecker
+		want    string
+	}{
+		"EquivalentOnRandomStates": {
+			a: maxProgram,
+			b: `
+	@R0
+	D=M
+	@R2
+	M=D
+	@R1
+	D=M
+	@R0
+	D=D-M
+	@END
+	D;JLE
+	@R1
+	D=M
+	@R2
+	M=D
+(END)
+	@END
+	0;JMP
+`,
+			checker: EquivalenceChecker{Inputs: []AddressRange{{From: 0, To: 1}}},
+			want:    "equivalent on 100 initial states\n",
+		},
+		"EquivalentOnEveryCombinationOfValues": {
+			a:       maxProgram,
+			b:       maxProgram,
+			checker: EquivalenceChecker{Inputs: []AddressRange{{From: 0, To: 1}}, Values: []uint16{0xffff, 0, 1}},
+			want:    "equivalent on 9 initial states\n",
+		},
+		"EquivalentOnUserSuppliedStates": {
+			a:       maxProgram,
+			b:       maxProgram,
+			checker: EquivalenceChecker{States: []map[uint16]uint16{{0: 3, 1: 7}}},
+			want:    "equivalent on 1 initial states\n",
+		},
+		"CompareOnlyGivenRegions": {
+			a:       maxProgram + "\t@R3\n\tM=1",
+			b:       maxProgram,
+			checker: EquivalenceChecker{Compare: []AddressRange{{From: 2, To: 2}}},
+			want:    "equivalent on 100 initial states\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got strings.Builder
+			err := tc.checker.Check(machineCode(t, tc.a), machineCode(t, tc.b), &got)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Check", name, got.String(), tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		a, b    string
+		checker EquivalenceChecker
+		want    string
+	}{
+		"ReportCounterexample": {
+			a: maxProgram,
+			b: strings.Replace(maxProgram, "D;JGT", "D;JLT", 1),
+			checker: EquivalenceChecker{
+				Inputs: []AddressRange{{From: 0, To: 1}},
+				Values: []uint16{0, 1},
+			},
+			want: `counterexample: 0=1 1=0
+  a halted after 11 cycles
+  b halted after 13 cycles
+  RAM[2]: a 1, b 0
+  first diverging write to RAM[2]: a 1 at cycle 10, b no write
+`,
+		},
+		"ReportFirstDivergingWrite": {
+			a: "\t@R0\n\tM=1\n\tM=M+1\n\tM=M+1",
+			b: "\t@R0\n\tM=1\n\tM=-1\n\tM=M+1",
+			checker: EquivalenceChecker{
+				States: []map[uint16]uint16{{}},
+			},
+			want: `counterexample:
+  a halted after 4 cycles
+  b halted after 4 cycles
+  RAM[0]: a 3, b 0
+  first diverging write to RAM[0]: a 2 at cycle 3, b -1 at cycle 3
+`,
+		},
+		"ReportProgramThatDoesNotHalt": {
+			a: maxProgram,
+			b: "(LOOP)\n\t@LOOP\n\tD;JMP",
+			checker: EquivalenceChecker{
+				States: []map[uint16]uint16{{0: 0, 1: 0}},
+				Cycles: 100,
+			},
+			want: `counterexample: 0=0 1=0
+  a halted after 13 cycles
+  b did not halt within 100 cycles
+`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			var got strings.Builder
+			err := tc.checker.Check(machineCode(t, tc.a), machineCode(t, tc.b), &got)
+			assertError(t, err)
+
+			assertDeepEquals(t, "Check", name, got.String(), tc.want)
+		})
+	}
+
+	t.Run("RejectTooManyCombinations", func(t *testing.T) {
+		checker := EquivalenceChecker{Values: []uint16{0, 1, 2, 3}}
+		err := checker.Check(machineCode(t, maxProgram), machineCode(t, maxProgram), new(strings.Builder))
+		assertError(t, err)
+	})
+}
+
+func TestParseAddressRanges(t *testing.T) {
+	got, err := ParseAddressRanges("R0-R15, 100,SCREEN-0x5fff")
+	assertNoError(t, err)
+
+	want := []AddressRange{{From: 0, To: 15}, {From: 100, To: 100}, {From: 16384, To: 24575}}
+	assertDeepEquals(t, "ParseAddressRanges", "", got, want)
+
+	for _, in := range []string{"", "R15-R0", "0-32768", "X"} {
+		_, err := ParseAddressRanges(in)
+		assertError(t, err)
+	}
+}
+
+func TestReadStates(t *testing.T) {
+	in := `
+// the first state
+R0=7 R1=-3
+0x100=0b1010, SP=256
+`
+	got, err := ReadStates(strings.NewReader(in))
+	assertNoError(t, err)
+
+	want := []map[uint16]uint16{{0: 7, 1: 0xfffd}, {256: 10, 0: 256}}
+	assertDeepEquals(t, "ReadStates", in, got, want)
+
+	for _, in := range []string{"R0", "R0=x", "X=1", "R0=-32769"} {
+		_, err := ReadStates(strings.NewReader(in))
+		assertError(t, err)
+	}
+}
+
+// machineCode assembles the hack assembly in.
+func machineCode(t *testing.T, in string) *bytes.Buffer {
+	t.Helper()
+
+	var machine bytes.Buffer
+	err := Assemble(strings.NewReader(in), &machine)
+	assertNoError(t, err)
+	return &machine
+}
@@ -0,0 +1,349 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is an integer expression over constants and symbols. Expressions can be used in
+// A-instructions like @SCREEN+32 and in constant declarations. They are evaluated once all labels
+// have been resolved.
+type expr interface {
+	fmt.Stringer
+	exprNode()
+}
+
+// numberExpr is a constant.
+type numberExpr struct {
+	Value int
+}
+
+// symbolExpr is a reference to a label, constant, variable or pre-defined symbol.
+type symbolExpr struct {
+	Name string
+}
+
+// unaryExpr applies a unary operator like - or ~ to X.
+type unaryExpr struct {
+	Op string
+	X  expr
+}
+
+// binaryExpr applies a binary operator like + or << to X and Y.
+type binaryExpr struct {
+	Op string
+	X  expr
+	Y  expr
+}
+
+func (numberExpr) exprNode() {}
+func (symbolExpr) exprNode() {}
+func (unaryExpr) exprNode()  {}
+func (binaryExpr) exprNode() {}
+
+func (e numberExpr) String() string { return strconv.Itoa(e.Value) }
+func (e symbolExpr) String() string { return e.Name }
+
+func (e unaryExpr) String() string {
+	if _, ok := e.X.(binaryExpr); ok {
+		return e.Op + "(" + e.X.String() + ")"
+	}
+	return e.Op + e.X.String()
+}
+
+func (e binaryExpr) String() string {
+	x, y := e.X.String(), e.Y.String()
+	if b, ok := e.X.(binaryExpr); ok && precedence[b.Op] < precedence[e.Op] {
+		x = "(" + x + ")"
+	}
+	if b, ok := e.Y.(binaryExpr); ok && precedence[b.Op] <= precedence[e.Op] {
+		y = "(" + y + ")"
+	}
+	return x + e.Op + y
+}
+
+// precedence of the binary operators. Operators with a higher precedence bind more tightly. The
+// precedence follows the one of C.
+var precedence = map[string]int{
+	"|":  1,
+	"&":  2,
+	"<<": 3,
+	">>": 3,
+	"+":  4,
+	"-":  4,
+	"*":  5,
+	"/":  5,
+}
+
+// parseExpr parses an integer expression. Operands are unsigned decimal, hexadecimal (0x4000) or
+// binary (0b1010) constants, character literals ('A') or symbols. Expressions support the binary
+// operators + - * / & | << >>, the unary operators - and ~ and parentheses.
+func parseExpr(in string) (expr, error) {
+	tokens, err := tokenizeExpr(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+// tokenizeExpr splits in into operands, operators and parentheses.
+func tokenizeExpr(in string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(in); {
+		r := rune(in[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'':
+			j := i + 1
+			if strings.HasPrefix(in[j:], `\`) {
+				j++
+			}
+			if j < len(in) {
+				j++
+			}
+			if j >= len(in) || in[j] != '\'' {
+				return nil, fmt.Errorf("unterminated character literal %s", in[i:])
+			}
+			tokens = append(tokens, in[i:j+1])
+			i = j + 1
+		case validSymbolChars(r):
+			j := i
+			for j < len(in) && validSymbolChars(rune(in[j])) {
+				j++
+			}
+			tokens = append(tokens, in[i:j])
+			i = j
+		case strings.HasPrefix(in[i:], "<<") || strings.HasPrefix(in[i:], ">>"):
+			tokens = append(tokens, in[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/&|~()", r):
+			tokens = append(tokens, in[i:i+1])
+			i++
+		default:
+			return nil, fmt.Errorf("illegal character %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// parseBinary parses binary expressions with operators of at least given precedence using precedence
+// climbing.
+func (p *exprParser) parseBinary(minPrecedence int) (expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		prec, ok := precedence[op]
+		if !ok || prec < minPrecedence {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{Op: op, X: x, Y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, errors.New("unexpected end of expression")
+	case t == "-" || t == "~":
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{Op: t, X: x}, nil
+	case t == "(":
+		x, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing closing )")
+		}
+		return x, nil
+	case t[0] == '\'':
+		v, err := parseChar(t)
+		if err != nil {
+			return nil, err
+		}
+		return numberExpr{Value: v}, nil
+	case unicode.IsDigit(rune(t[0])):
+		v, err := parseNumber(t)
+		if err != nil {
+			return nil, err
+		}
+		return numberExpr{Value: v}, nil
+	case isSymbol(t):
+		return symbolExpr{Name: t}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t)
+}
+
+// parseNumber parses an unsigned 16-bit number in decimal, hexadecimal with prefix 0x or binary with
+// prefix 0b.
+func parseNumber(t string) (int, error) {
+	base := 10
+	digits := t
+	if len(t) > 2 && t[0] == '0' && (t[1] == 'x' || t[1] == 'X') {
+		base, digits = 16, t[2:]
+	} else if len(t) > 2 && t[0] == '0' && (t[1] == 'b' || t[1] == 'B') {
+		base, digits = 2, t[2:]
+	}
+	v, err := strconv.ParseUint(digits, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q: expected an unsigned 16-bit value", t)
+	}
+	return int(v), nil
+}
+
+// hackEscapes maps escape sequences in character literals to the Hack character set. Keys such as
+// newline or backspace which are not printable are mapped to codes 128 and up.
+var hackEscapes = map[string]int{
+	`\n`: 128,
+	`\b`: 129,
+	`\\`: '\\',
+	`\'`: '\'',
+}
+
+// parseChar parses a character literal like 'A' or '\n' into its code in the Hack character set.
+// Printable ASCII characters keep their code.
+func parseChar(t string) (int, error) {
+	c := t[1 : len(t)-1]
+	if v, ok := hackEscapes[c]; ok {
+		return v, nil
+	}
+	if len(c) != 1 || c[0] < ' ' || c[0] > '~' {
+		return 0, fmt.Errorf("invalid character literal %s: expected a printable ASCII character or one of the escapes \\n, \\b, \\\\ or \\'", t)
+	}
+	return int(c[0]), nil
+}
+
+// evalExpr evaluates e. Symbols are resolved using lookup.
+func evalExpr(e expr, lookup func(symbol string) (int, error)) (int, error) {
+	switch e := e.(type) {
+	case numberExpr:
+		return e.Value, nil
+	case symbolExpr:
+		return lookup(e.Name)
+	case unaryExpr:
+		x, err := evalExpr(e.X, lookup)
+		if err != nil {
+			return 0, err
+		}
+		if e.Op == "-" {
+			return -x, nil
+		}
+		return ^x, nil
+	case binaryExpr:
+		x, err := evalExpr(e.X, lookup)
+		if err != nil {
+			return 0, err
+		}
+		y, err := evalExpr(e.Y, lookup)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		case "/":
+			if y == 0 {
+				return 0, fmt.Errorf("division by zero in %s", e)
+			}
+			return x / y, nil
+		case "&":
+			return x & y, nil
+		case "|":
+			return x | y, nil
+		case "<<", ">>":
+			if y < 0 || y > 15 {
+				return 0, fmt.Errorf("shift count %d in %s must be between 0 and 15", y, e)
+			}
+			if e.Op == "<<" {
+				return x << y, nil
+			}
+			return x >> y, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported expression %s", e)
+}
+
+// mapSymbols returns a copy of e with every symbol replaced by the result of f.
+func mapSymbols(e expr, f func(symbol string) string) expr {
+	switch e := e.(type) {
+	case symbolExpr:
+		return symbolExpr{Name: f(e.Name)}
+	case unaryExpr:
+		return unaryExpr{Op: e.Op, X: mapSymbols(e.X, f)}
+	case binaryExpr:
+		return binaryExpr{Op: e.Op, X: mapSymbols(e.X, f), Y: mapSymbols(e.Y, f)}
+	}
+	return e
+}
+
+// hasSymbols returns true if e refers to any symbol.
+func hasSymbols(e expr) bool {
+	found := false
+	mapSymbols(e, func(symbol string) string {
+		found = true
+		return symbol
+	})
+	return found
+}
+
+// evalConstant evaluates e and ensures it results in an unsigned 15-bit value as required by an
+// A-instruction.
+func evalConstant(e expr, lookup func(symbol string) (int, error)) (uint16, error) {
+	v, err := evalExpr(e, lookup)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 1<<15-1 {
+		return 0, fmt.Errorf("expression %s evaluates to %d which is not an unsigned 15-bit value", e, v)
+	}
+	return uint16(v), nil
+}
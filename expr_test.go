@@ -0,0 +1,205 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want expr
+	}{
+		"Number": {
+			in:   "32",
+			want: numberExpr{Value: 32},
+		},
+		"MultiplicationBindsTighterThanAddition": {
+			in: "SCREEN+ROWS*32",
+			want: binaryExpr{
+				Op: "+",
+				X:  symbolExpr{Name: "SCREEN"},
+				Y: binaryExpr{
+					Op: "*",
+					X:  symbolExpr{Name: "ROWS"},
+					Y:  numberExpr{Value: 32},
+				},
+			},
+		},
+		"SubtractionIsLeftAssociative": {
+			in: "8 - 4 - 2",
+			want: binaryExpr{
+				Op: "-",
+				X:  binaryExpr{Op: "-", X: numberExpr{Value: 8}, Y: numberExpr{Value: 4}},
+				Y:  numberExpr{Value: 2},
+			},
+		},
+		"Parentheses": {
+			in: "~(LOOP+1)<<2",
+			want: binaryExpr{
+				Op: "<<",
+				X: unaryExpr{
+					Op: "~",
+					X:  binaryExpr{Op: "+", X: symbolExpr{Name: "LOOP"}, Y: numberExpr{Value: 1}},
+				},
+				Y: numberExpr{Value: 2},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseExpr(tc.in)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "parseExpr", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectEmpty": {
+			in: "",
+		},
+		"RejectMissingOperand": {
+			in: "SCREEN+",
+		},
+		"RejectMissingClosingParenthesis": {
+			in: "(SCREEN+1",
+		},
+		"RejectAdjacentOperands": {
+			in: "SCREEN 1",
+		},
+		"RejectIllegalCharacter": {
+			in: "SCREEN%2",
+		},
+		"RejectInvalidNumber": {
+			in: "2A+1",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseExpr(tc.in)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestExprString(t *testing.T) {
+	tests := []string{
+		"SCREEN+ROWS*32",
+		"(SCREEN+ROWS)*32",
+		"8-(4-2)",
+		"~(LOOP+1)<<2",
+		"-ROWS",
+		"KBD&255|1",
+	}
+
+	for _, in := range tests {
+		e, err := parseExpr(in)
+		assertNoError(t, err)
+
+		assertDeepEquals(t, "String", in, e.String(), in)
+	}
+}
+
+func TestEvalExpr(t *testing.T) {
+	symbols := map[string]int{"ROWS": 256, "SCREEN": 16384}
+	lookup := func(symbol string) (int, error) {
+		return symbols[symbol], nil
+	}
+	tests := map[string]int{
+		"SCREEN+ROWS*32": 24576,
+		"(1+2)*3":        9,
+		"7/2":            3,
+		"~0&255":         255,
+		"6|1":            7,
+		"1<<14":          16384,
+		"SCREEN>>8":      64,
+		"-ROWS+300":      44,
+	}
+
+	for in, want := range tests {
+		e, err := parseExpr(in)
+		assertNoError(t, err)
+
+		got, err := evalExpr(e, lookup)
+		assertNoError(t, err)
+
+		assertEquals(t, "evalExpr", in, want, got)
+	}
+
+	errTests := []string{"1/0", "1<<16", "1>>-1"}
+	for _, in := range errTests {
+		e, err := parseExpr(in)
+		assertNoError(t, err)
+
+		_, err = evalExpr(e, lookup)
+		assertError(t, err)
+	}
+}
+
+func TestAssembleExpressions(t *testing.T) {
+	in := `
+.equ ROWS 8
+.equ SIZE ROWS*32
+(LOOP)
+	@SCREEN+32
+	@LOOP+2
+	@SIZE
+	@(ROWS+1)*2
+	@.done-1
+(.done)
+	@20/3
+`
+	want := `0100000000100000
+0000000000000010
+0000000100000000
+0000000000010010
+0000000000000100
+0000000000000110
+`
+	var got bytes.Buffer
+	err := Assemble(strings.NewReader(in), &got)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Assemble", in, got.String(), want)
+
+	errTests := map[string]struct {
+		in   string
+		want string
+	}{
+		"RejectOverflow": {
+			in:   "@SCREEN*2",
+			want: `line 1: failed to encode A-instruction "@SCREEN*2": expression SCREEN*2 evaluates to 32768`,
+		},
+		"RejectNegativeResult": {
+			in:   "@R1-2",
+			want: `line 1: failed to encode A-instruction "@R1-2": expression R1-2 evaluates to -1`,
+		},
+		"RejectConstantOverflow": {
+			in: `
+.equ BIG KBD*2
+`,
+			want: `line 2: failed to resolve constant "BIG": expression KBD*2 evaluates to 49152`,
+		},
+		"RejectUndefinedSymbol": {
+			in:   "@UNDEFINED+1",
+			want: `line 1: failed to encode A-instruction "@UNDEFINED+1": undefined symbol "UNDEFINED"`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			err := Assemble(strings.NewReader(tc.in), new(bytes.Buffer))
+			assertError(t, err)
+
+			if !strings.HasPrefix(err.Error(), tc.want) {
+				t.Errorf("Assemble(%q) = %q; want error starting with %q", tc.in, err, tc.want)
+			}
+		})
+	}
+}
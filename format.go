@@ -0,0 +1,205 @@
+package hack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format formats the hack assembly read from r into its canonical layout written to w. Labels and
+// directives are flush left while instructions, pseudo-instructions and macro invocations are
+// indented by a tab. Whitespace within instructions is removed and operands are separated by a
+// comma and a space. Comments of consecutive lines with the same indentation are aligned. Comments
+// on their own line and single blank lines separating groups of lines are kept. Formatting a
+// formatted program does not change it.
+func Format(r io.Reader, w io.Writer) error {
+	var lines []formattedLine
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lines = append(lines, formatLine(s.Text()))
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("failed to read assembly: %v", err)
+	}
+
+	lines = collapseBlankLines(lines)
+	alignComments(lines)
+	bw := bufio.NewWriter(w)
+	for _, l := range lines {
+		bw.WriteString(l.String())
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// formattedLine is a line in canonical layout.
+type formattedLine struct {
+	Indent  string
+	Code    string
+	Comment string
+	// Padding is the number of spaces between the code and the comment.
+	Padding int
+}
+
+func (l formattedLine) String() string {
+	if l.Code == "" && l.Comment == "" {
+		return ""
+	}
+	if l.Code == "" {
+		return l.Indent + l.Comment
+	}
+	if l.Comment == "" {
+		return l.Indent + l.Code
+	}
+	return l.Indent + l.Code + strings.Repeat(" ", l.Padding) + l.Comment
+}
+
+// formatLine formats a single line.
+func formatLine(text string) formattedLine {
+	code, comment := cutComment(text)
+	code = strings.TrimSpace(code)
+	comment = strings.TrimRightFunc(comment, unicode.IsSpace)
+
+	var l formattedLine
+	l.Comment = comment
+	switch {
+	case code == "":
+		// comments on their own line stay flush left or are indented like instructions
+		if strings.TrimLeftFunc(text, unicode.IsSpace) != text {
+			l.Indent = "\t"
+		}
+	case code[0] == '(':
+		l.Code = "(" + strings.TrimSpace(strings.TrimSuffix(code[1:], ")")) + ")"
+	case code[0] == '.':
+		l.Code = collapseSpaces(code)
+	case code[0] == '@':
+		l.Indent, l.Code = "\t", removeSpaces(code)
+	default:
+		l.Indent, l.Code = "\t", formatCommand(code)
+	}
+	return l
+}
+
+// formatCommand formats a C-instruction, a pseudo-instruction or a macro invocation.
+func formatCommand(code string) string {
+	compact := removeSpaces(code)
+	if c, err := parseCInstruction(compact); err == nil {
+		if _, err := codeCInstruction(c); err == nil {
+			return c.String()
+		}
+	}
+
+	mnemonic, operands := cutField(code)
+	if operands == "" {
+		return mnemonic
+	}
+	return mnemonic + " " + strings.Join(splitOperands(operands), ", ")
+}
+
+// cutComment splits text into the code and the comment starting with //. Slashes within character
+// literals or strings do not start a comment.
+func cutComment(text string) (code, comment string) {
+	var quote rune
+	var escaped bool
+	for i, r := range text {
+		switch {
+		case escaped:
+			// the escaped character cannot end the literal
+			escaped = false
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		case quote == 0 && strings.HasPrefix(text[i:], "//"):
+			return text[:i], text[i:]
+		}
+	}
+	return text, ""
+}
+
+// removeSpaces removes all whitespace that is not part of a character literal or string.
+func removeSpaces(code string) string {
+	return mapSpaces(code, func(b *strings.Builder, inSpace bool) {})
+}
+
+// collapseSpaces replaces every sequence of whitespace that is not part of a character literal or
+// string by a single space.
+func collapseSpaces(code string) string {
+	return mapSpaces(code, func(b *strings.Builder, inSpace bool) {
+		if !inSpace {
+			b.WriteByte(' ')
+		}
+	})
+}
+
+// mapSpaces copies code calling space for every whitespace rune outside of quotes instead of
+// copying it. inSpace is true if the previous rune was whitespace as well.
+func mapSpaces(code string, space func(b *strings.Builder, inSpace bool)) string {
+	var b strings.Builder
+	var quote rune
+	var inSpace, escaped bool
+	for _, r := range code {
+		if quote == 0 && unicode.IsSpace(r) {
+			space(&b, inSpace)
+			inSpace = true
+			continue
+		}
+		inSpace = false
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// collapseBlankLines removes blank lines at the start and end and reduces every sequence of blank
+// lines to a single one.
+func collapseBlankLines(lines []formattedLine) []formattedLine {
+	var out []formattedLine
+	for _, l := range lines {
+		blank := l.Code == "" && l.Comment == ""
+		if blank && (len(out) == 0 || out[len(out)-1].String() == "") {
+			continue
+		}
+		out = append(out, l)
+	}
+	for len(out) > 0 && out[len(out)-1].String() == "" {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// alignComments aligns the comments following code on consecutive lines with the same indentation
+// one space after the longest code.
+func alignComments(lines []formattedLine) {
+	trailing := func(l formattedLine) bool {
+		return l.Code != "" && l.Comment != ""
+	}
+	for start := 0; start < len(lines); {
+		if !trailing(lines[start]) {
+			start++
+			continue
+		}
+		end, width := start, 0
+		for ; end < len(lines) && trailing(lines[end]) && lines[end].Indent == lines[start].Indent; end++ {
+			width = max(width, utf8.RuneCountInString(lines[end].Code))
+		}
+		for i := start; i < end; i++ {
+			lines[i].Padding = width - utf8.RuneCountInString(lines[i].Code) + 1
+		}
+		start = end
+	}
+}
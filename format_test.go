@@ -0,0 +1,135 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want string
+	}{
+		"IndentInstructionsAndNotLabels": {
+			in: `
+			   @0
+			   D=M
+			   @INFINITE_LOOP
+			   D;JLE
+			(INFINITE_LOOP)
+			   @INFINITE_LOOP
+			   0;JMP
+`,
+			want: `	@0
+	D=M
+	@INFINITE_LOOP
+	D;JLE
+(INFINITE_LOOP)
+	@INFINITE_LOOP
+	0;JMP
+`,
+		},
+		"NormalizeSpacing": {
+			in: `( LOOP )
+  AM = M - 1 ; JGT
+  @ sum
+  D ; JMP
+  0;   JMP
+`,
+			want: `(LOOP)
+	AM=M-1;JGT
+	@sum
+	D;JMP
+	0;JMP
+`,
+		},
+		"EscapedQuoteFollowedByComment": {
+			in:   "\t@'\\''   // load a quote\n\t@'\\\\'// load a backslash\n",
+			want: "\t@'\\'' // load a quote\n\t@'\\\\' // load a backslash\n",
+		},
+		"AlignTrailingComments": {
+			in: `
+	@R0             // first operand
+	D=M // D = first number
+(STORE) // store the maximum
+	@R2  // second
+	M=D//   keep the spaces
+`,
+			want: `	@R0 // first operand
+	D=M // D = first number
+(STORE) // store the maximum
+	@R2 // second
+	M=D //   keep the spaces
+`,
+		},
+		"KeepCommentsAndBlankLineGrouping": {
+			in: `
+
+// Computes R2 = max(R0, R1)
+   // indented comment
+
+
+	@R0
+	D=M
+
+	@R1
+
+
+`,
+			want: `// Computes R2 = max(R0, R1)
+	// indented comment
+
+	@R0
+	D=M
+
+	@R1
+`,
+		},
+		"DirectivesAndPseudoInstructions": {
+			in: `.equ   WIDTH   32
+.macro  inc  x
+	@x
+	M = M + 1
+.endm
+	MOV   R1 ,R0
+	inc    counter
+	PUSH D
+`,
+			want: `.equ WIDTH 32
+.macro inc x
+	@x
+	M=M+1
+.endm
+	MOV R1, R0
+	inc counter
+	PUSH D
+`,
+		},
+		"KeepLiterals": {
+			in: `.equ  SPACE   ' '  // a space
+	@ ' '   // space
+	@'/'
+`,
+			want: `.equ SPACE ' ' // a space
+	@' ' // space
+	@'/'
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got strings.Builder
+			err := Format(strings.NewReader(tc.in), &got)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Format", tc.in, got.String(), tc.want)
+
+			var again strings.Builder
+			err = Format(strings.NewReader(got.String()), &again)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Format", got.String(), again.String(), got.String())
+		})
+	}
+}
@@ -2,4 +2,4 @@ module teleivo/nand2tetris/hack-assembler
 
 go 1.21.1
 
-require github.com/google/go-cmp v0.6.0 // indirect
+require github.com/google/go-cmp v0.6.0
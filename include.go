@@ -0,0 +1,83 @@
+package hack
+
+import (
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// preprocess evaluates conditional assembly in the lines of a file and then resolves its includes.
+// Includes in branches that are not assembled are not read. The stack holds the files currently
+// being included, the file the lines belong to last.
+func (a *Assembler) preprocess(lines []line, stack []string) ([]line, error) {
+	lines, err := evalConditionals(lines, a.Defines)
+	if err != nil {
+		return nil, err
+	}
+	return a.resolveIncludes(lines, stack)
+}
+
+// resolveIncludes replaces every .include directive in lines with the preprocessed lines of the
+// included file. Included files are read from a.FS relative to the directory of the including file
+// and can include other files themselves. The stack holds the files currently being included, the
+// including file last, so that include cycles can be detected.
+func (a *Assembler) resolveIncludes(lines []line, stack []string) ([]line, error) {
+	var out []line
+	for _, l := range lines {
+		if directive(l) != ".include" {
+			out = append(out, l)
+			continue
+		}
+
+		name, err := parseInclude(l)
+		if err != nil {
+			return nil, err
+		}
+		if a.FS == nil {
+			return nil, errorf(l.Pos, "failed to include %q: no file system to read from", name)
+		}
+		name = path.Join(path.Dir(l.Pos.File), name)
+		for i, file := range stack {
+			if file == name {
+				cycle := strings.Join(append(stack[i:], name), " -> ")
+				return nil, errorf(l.Pos, "failed to include %q: include cycle %s", name, cycle)
+			}
+		}
+
+		included, err := readFile(a.FS, name)
+		if err != nil {
+			return nil, errorf(l.Pos, "failed to include %q: %v", name, err)
+		}
+		included, err = a.preprocess(included, append(stack, name))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, included...)
+	}
+	return out, nil
+}
+
+// parseInclude returns the quoted file name of the include directive on line l.
+func parseInclude(l line) (string, error) {
+	arg := strings.TrimSpace(strings.TrimPrefix(l.Command(), ".include"))
+	name, err := strconv.Unquote(arg)
+	if err != nil || arg[0] != '"' {
+		return "", errorf(l.Pos, "failed to parse include: expected a file name enclosed in \"\" instead got %q", arg)
+	}
+	if name == "" {
+		return "", errorf(l.Pos, "failed to parse include: file name must not be empty")
+	}
+	return name, nil
+}
+
+// readFile reads all lines of file name in fsys.
+func readFile(fsys fs.FS, name string) ([]line, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLines(f, name)
+}
@@ -0,0 +1,109 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssembleFileWithIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.asm": {Data: []byte(`
+	@2
+	D=A
+.include "lib/set.asm"
+`)},
+		"lib/set.asm": {Data: []byte(`
+.include "r0.asm"
+	M=D
+`)},
+		"lib/r0.asm": {Data: []byte(`
+	@R0
+`)},
+	}
+	asm := Assembler{FS: fsys}
+
+	var got bytes.Buffer
+	err := asm.AssembleFile("main.asm", &got)
+	assertNoError(t, err)
+
+	want := `0000000000000010
+1110110000010000
+0000000000000000
+1110001100001000
+`
+	assertDeepEquals(t, "AssembleFile", "main.asm", got.String(), want)
+
+	errTests := map[string]struct {
+		fsys fstest.MapFS
+		want string
+	}{
+		"RejectIncludeCycle": {
+			fsys: fstest.MapFS{
+				"main.asm": {Data: []byte(`.include "a.asm"`)},
+				"a.asm":    {Data: []byte(`.include "b.asm"`)},
+				"b.asm":    {Data: []byte(`.include "a.asm"`)},
+			},
+			want: "b.asm:1: failed to include \"a.asm\": include cycle a.asm -> b.asm -> a.asm",
+		},
+		"RejectMissingFile": {
+			fsys: fstest.MapFS{
+				"main.asm": {Data: []byte("\n.include \"missing.asm\"")},
+			},
+			want: "main.asm:2: failed to include \"missing.asm\"",
+		},
+		"RejectUnquotedFileName": {
+			fsys: fstest.MapFS{
+				"main.asm": {Data: []byte(`.include lib.asm`)},
+			},
+			want: "main.asm:1: failed to parse include",
+		},
+		"ErrorNamesIncludedFile": {
+			fsys: fstest.MapFS{
+				"main.asm":    {Data: []byte(`.include "lib/bad.asm"`)},
+				"lib/bad.asm": {Data: []byte("@0\n(BAD")},
+			},
+			want: "lib/bad.asm:2: failed to parse label",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			asm := Assembler{FS: tc.fsys}
+
+			err := asm.AssembleFile("main.asm", new(bytes.Buffer))
+			assertError(t, err)
+
+			if !strings.HasPrefix(err.Error(), tc.want) {
+				t.Errorf("AssembleFile(%q) = %q; want error starting with %q", "main.asm", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestAssembleRejectsIncludeWithoutFS(t *testing.T) {
+	err := Assemble(strings.NewReader(`.include "lib.asm"`), new(bytes.Buffer))
+	assertError(t, err)
+}
+
+func TestListing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.asm": {Data: []byte(`(START)
+	@2
+.include "lib.asm"
+`)},
+		"lib.asm": {Data: []byte(`	D=A`)},
+	}
+	var listing bytes.Buffer
+	asm := Assembler{FS: fsys, Listing: &listing}
+
+	err := asm.AssembleFile("main.asm", new(bytes.Buffer))
+	assertNoError(t, err)
+
+	want := `0                    (START)  main.asm:1
+0  0000000000000010  @2       main.asm:2
+1  1110110000010000  D=A      lib.asm:1
+`
+	assertDeepEquals(t, "AssembleFile", "main.asm", listing.String(), want)
+}
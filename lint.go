@@ -0,0 +1,381 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Severity is the severity of the findings of a lint rule.
+type Severity int
+
+const (
+	// SeverityOff disables a rule.
+	SeverityOff Severity = iota
+	SeverityInfo
+	SeverityWarning
+	// SeverityError marks findings that fail the lint.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityOff:
+		return "off"
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// parseSeverity parses a severity like warning.
+func parseSeverity(s string) (Severity, error) {
+	for severity := SeverityOff; severity <= SeverityError; severity++ {
+		if severity.String() == s {
+			return severity, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid severity %q: expected off, info, warning or error", s)
+}
+
+// LintRule is a check for code the assembler accepts but that is likely a bug.
+type LintRule struct {
+	ID string
+	// Severity is the default severity of the findings of the rule.
+	Severity    Severity
+	Description string
+	check       func(p *lintProgram) []lintFinding
+}
+
+// lintRules are the rules of the linter in the order they are checked.
+var lintRules = []LintRule{
+	{
+		ID:          "unused-label",
+		Severity:    SeverityWarning,
+		Description: "label is never referenced",
+		check:       unusedLabels,
+	},
+	{
+		ID:          "single-use-symbol",
+		Severity:    SeverityWarning,
+		Description: "undeclared symbol is used only once, likely a typo that turned into a variable",
+		check:       singleUseSymbols,
+	},
+	{
+		ID:          "jump-to-variable",
+		Severity:    SeverityError,
+		Description: "jump to the RAM address of a variable loaded into A",
+		check:       jumpsToVariables,
+	},
+	{
+		ID:          "a-dest-reads-m",
+		Severity:    SeverityInfo,
+		Description: "dest writes A and M while comp reads M, M is written at the address A held before",
+		check:       aDestReadsM,
+	},
+	{
+		ID:          "label-at-end",
+		Severity:    SeverityError,
+		Description: "label is not followed by any instruction, jumping to it runs past the program",
+		check:       labelsAtEnd,
+	},
+}
+
+// LintRules returns the rules of the linter with their default severity.
+func LintRules() []LintRule {
+	return append([]LintRule(nil), lintRules...)
+}
+
+// LintConfig overrides the default severity of lint rules by their ID. Rules are disabled using
+// SeverityOff.
+type LintConfig map[string]Severity
+
+// ReadLintConfig reads a lint configuration from r. The configuration is attributed to the file name
+// in errors. Every line sets the severity of a rule like
+//
+//	unused-label off
+//	a-dest-reads-m error
+func ReadLintConfig(r io.Reader, name string) (LintConfig, error) {
+	lines, err := readLines(r, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for _, rule := range lintRules {
+		ids[rule.ID] = true
+	}
+	config := make(LintConfig)
+	for _, l := range lines {
+		fields := strings.Fields(l.Command())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errorf(l.Pos, "failed to parse lint config: expected \"RULE_ID SEVERITY\" instead got %q", l.Command())
+		}
+		if !ids[fields[0]] {
+			return nil, errorf(l.Pos, "failed to parse lint config: unknown rule %q", fields[0])
+		}
+		severity, err := parseSeverity(fields[1])
+		if err != nil {
+			return nil, errorf(l.Pos, "failed to parse lint config: %v", err)
+		}
+		config[fields[0]] = severity
+	}
+	return config, nil
+}
+
+// ErrLintFailed is returned by Lint if a rule with severity error found a problem.
+var ErrLintFailed = errors.New("lint found errors")
+
+// Linter reports code that the assembler accepts but that is likely a bug. The zero value checks
+// every rule with its default severity.
+type Linter struct {
+	// FS is used to resolve .include directives like the FS of an Assembler.
+	FS fs.FS
+	// Defines holds the names tested by the conditional assembly directives like the Defines of an
+	// Assembler.
+	Defines map[string]string
+	// StackPointer is the symbol used by the PUSH and POP pseudo-instructions like the StackPointer
+	// of an Assembler.
+	StackPointer string
+	// Config overrides the default severity of the rules.
+	Config LintConfig
+}
+
+// lintFinding is a problem found by a lint rule.
+type lintFinding struct {
+	// index is the index of the instruction the finding is about.
+	index    int
+	pos      pos
+	message  string
+	rule     string
+	severity Severity
+}
+
+// Lint reads the hack assembly in file name from r and writes the findings of every enabled rule to
+// w in source order. Every finding shows the position, the severity, the message and the rule ID.
+// Code of the standard library is not linted and findings can be suppressed using comments like
+// // hack:ignore RULE_ID. Lint returns ErrLintFailed if a rule with severity
+// error found a problem.
+func (l *Linter) Lint(r io.Reader, name string, w io.Writer) error {
+	a := &Assembler{FS: l.FS, Defines: l.Defines, StackPointer: l.StackPointer}
+	lines, suppressions, err := a.source(r, name)
+	if err != nil {
+		return err
+	}
+	instructions, err := a.parseSource(lines)
+	if err != nil {
+		return err
+	}
+	instructions, err = includeStd(instructions)
+	if err != nil {
+		return err
+	}
+	p := newLintProgram(instructions, lines)
+
+	var findings []lintFinding
+	for _, rule := range lintRules {
+		severity := rule.Severity
+		if s, ok := l.Config[rule.ID]; ok {
+			severity = s
+		}
+		if severity == SeverityOff {
+			continue
+		}
+		for _, f := range rule.check(p) {
+			if strings.HasPrefix(f.pos.File, "std/") || suppressed(suppressions, rule.ID, f.pos) {
+				continue
+			}
+			f.rule, f.severity = rule.ID, severity
+			findings = append(findings, f)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].index < findings[j].index
+	})
+
+	var failed bool
+	for _, f := range findings {
+		if _, err := fmt.Fprintf(w, "%s: %s: %s [%s]\n", f.pos, f.severity, f.message, f.rule); err != nil {
+			return err
+		}
+		failed = failed || f.severity == SeverityError
+	}
+	if failed {
+		return ErrLintFailed
+	}
+	return nil
+}
+
+// lintProgram is a parsed program together with what the lint rules need to know about it.
+type lintProgram struct {
+	instructions []instruction
+	// commands holds the command of the source line at every position.
+	commands map[string]string
+	// declared holds the kind of every label, constant, variable and block.
+	declared map[string]symbolKind
+	// references counts the references to every symbol.
+	references map[string]int
+}
+
+func newLintProgram(instructions []instruction, lines []line) *lintProgram {
+	p := &lintProgram{
+		instructions: instructions,
+		commands:     make(map[string]string),
+		declared:     make(map[string]symbolKind),
+		references:   make(map[string]int),
+	}
+	for _, l := range lines {
+		p.commands[l.Pos.String()] = l.Command()
+	}
+
+	reference := func(e expr) {
+		mapSymbols(e, func(symbol string) string {
+			p.references[symbol]++
+			return symbol
+		})
+	}
+	for _, ins := range instructions {
+		switch ins := ins.(type) {
+		case *label:
+			p.declared[ins.Literal] = labelSymbol
+		case *constant:
+			p.declared[ins.Name] = constantSymbol
+			if ins.IsSymbol {
+				p.references[ins.Literal]++
+			}
+			if ins.Expr != nil {
+				reference(ins.Expr)
+			}
+		case *variable:
+			p.declared[ins.Name] = variableSymbol
+			if ins.Address != nil {
+				reference(ins.Address)
+			}
+		case *array:
+			p.declared[ins.Name] = blockSymbol
+			reference(ins.Size)
+		case *aInstruction:
+			if ins.IsSymbol || ins.Expr != nil {
+				reference(operandExpr(ins))
+			}
+		case *entry:
+			p.references[ins.Label]++
+			for _, init := range ins.Init {
+				_, value, _ := strings.Cut(init, "=")
+				if a, err := parseAInstruction("@" + value); err == nil && (a.IsSymbol || a.Expr != nil) {
+					reference(operandExpr(a))
+				}
+			}
+		}
+	}
+	return p
+}
+
+// isVariable returns true if symbol refers to RAM allocated for a variable or block, either
+// declared or implicitly by referencing a symbol that is not declared.
+func (p *lintProgram) isVariable(symbol string) bool {
+	kind, ok := p.declared[symbol]
+	if !ok {
+		_, predefined := predefinedSymbols[symbol]
+		return !predefined
+	}
+	return kind == variableSymbol || kind == blockSymbol
+}
+
+// isPseudoInstruction returns true if the source line at position p holds a pseudo-instruction.
+func (p *lintProgram) isPseudoInstruction(at pos) bool {
+	mnemonic, _ := cutField(p.commands[at.String()])
+	_, ok := pseudoInstructions[mnemonic]
+	return ok
+}
+
+func unusedLabels(p *lintProgram) []lintFinding {
+	var findings []lintFinding
+	for i, ins := range p.instructions {
+		if l, ok := ins.(*label); ok && !l.Generated && p.references[l.Literal] == 0 {
+			findings = append(findings, lintFinding{index: i, pos: l.Pos, message: fmt.Sprintf("label %q is never referenced", l.Literal)})
+		}
+	}
+	return findings
+}
+
+func singleUseSymbols(p *lintProgram) []lintFinding {
+	var findings []lintFinding
+	for i, ins := range p.instructions {
+		a, ok := ins.(*aInstruction)
+		if !ok || (!a.IsSymbol && a.Expr == nil) {
+			continue
+		}
+		mapSymbols(operandExpr(a), func(symbol string) string {
+			if _, declared := p.declared[symbol]; !declared && p.isVariable(symbol) && p.references[symbol] == 1 {
+				findings = append(findings, lintFinding{index: i, pos: a.Pos, message: fmt.Sprintf("symbol %q is used only once and allocated as a variable, is it a typo?", symbol)})
+			}
+			return symbol
+		})
+	}
+	return findings
+}
+
+func jumpsToVariables(p *lintProgram) []lintFinding {
+	var findings []lintFinding
+	for i := 1; i < len(p.instructions); i++ {
+		c, ok := p.instructions[i].(*cInstruction)
+		if !ok || c.Jump == "" {
+			continue
+		}
+		a, ok := p.instructions[i-1].(*aInstruction)
+		if !ok || (!a.IsSymbol && a.Expr == nil) {
+			continue
+		}
+		var variable string
+		mapSymbols(operandExpr(a), func(symbol string) string {
+			if variable == "" && p.isVariable(symbol) {
+				variable = symbol
+			}
+			return symbol
+		})
+		if variable != "" {
+			findings = append(findings, lintFinding{index: i, pos: c.Pos, message: fmt.Sprintf("%q jumps to the RAM address of variable %q loaded by %q", c, variable, a)})
+		}
+	}
+	return findings
+}
+
+func aDestReadsM(p *lintProgram) []lintFinding {
+	var findings []lintFinding
+	for i, ins := range p.instructions {
+		c, ok := ins.(*cInstruction)
+		if !ok || !strings.Contains(c.Dest, "A") || !strings.Contains(c.Dest, "M") || !strings.Contains(c.Comp, "M") {
+			continue
+		}
+		if p.isPseudoInstruction(c.Pos) {
+			continue
+		}
+		findings = append(findings, lintFinding{index: i, pos: c.Pos, message: fmt.Sprintf("%q writes A and M while reading M, M is written at the address A held before the instruction", c)})
+	}
+	return findings
+}
+
+func labelsAtEnd(p *lintProgram) []lintFinding {
+	var findings []lintFinding
+	for i := len(p.instructions) - 1; i >= 0; i-- {
+		ins := p.instructions[i]
+		if isCode(ins) && !strings.HasPrefix(codePos(ins).File, "std/") {
+			break
+		}
+		if l, ok := ins.(*label); ok && !strings.HasPrefix(l.Pos.File, "std/") {
+			findings = append(findings, lintFinding{index: i, pos: l.Pos, message: fmt.Sprintf("label %q is not followed by any instruction, jumping to it runs past the program", l.Literal)})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,170 @@
+package hack
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := map[string]struct {
+		in     string
+		config LintConfig
+		want   string
+		err    error
+	}{
+		"NoFindings": {
+			in:   maxProgram,
+			want: "",
+		},
+		"UnusedLabel": {
+			in: `
+(START)
+	@R0
+	D=M
+(END)
+	@END
+	0;JMP
+`,
+			want: "line 2: warning: label \"START\" is never referenced [unused-label]\n",
+		},
+		"LabelReferencedByEntryOrExpressionIsUsed": {
+			in: `
+.entry Main
+.equ AFTER LOOP+2
+(Main)
+	@AFTER
+(LOOP)
+	D=D-1
+(END)
+	@END
+	0;JMP
+`,
+			want: "",
+		},
+		"SingleUseSymbol": {
+			in: `
+	@counter
+	M=1
+	@countr
+	M=M-1
+	@counter
+	D=M
+`,
+			want: "line 4: warning: symbol \"countr\" is used only once and allocated as a variable, is it a typo? [single-use-symbol]\n",
+		},
+		"DeclaredVariableUsedOnceIsNotATypo": {
+			in: `
+.var counter
+	@counter
+	M=1
+`,
+			want: "",
+		},
+		"JumpToVariable": {
+			in: `
+.var target
+	@target
+	M=D
+	@target
+	0;JMP
+`,
+			want: "line 6: error: \"0;JMP\" jumps to the RAM address of variable \"target\" loaded by \"@target\" [jump-to-variable]\n",
+			err:  ErrLintFailed,
+		},
+		"JumpToPointerIsFine": {
+			in: `
+	@R13
+	A=M
+	0;JMP
+`,
+			want: "",
+		},
+		"ADestReadsM": {
+			in: `
+	@SP
+	AM=M-1
+	D=M
+	PUSH D
+`,
+			want: "line 3: info: \"AM=M-1\" writes A and M while reading M, M is written at the address A held before the instruction [a-dest-reads-m]\n",
+		},
+		"LabelAtEnd": {
+			in: `
+	@DONE
+	D;JEQ
+	D=0
+(DONE)
+`,
+			want: "line 5: error: label \"DONE\" is not followed by any instruction, jumping to it runs past the program [label-at-end]\n",
+			err:  ErrLintFailed,
+		},
+		"LabelAtEndIsNotFollowedByTheStandardLibrary": {
+			in: `
+	@std.mul
+	0;JMP
+(DONE)
+	@DONE
+	D;JMP
+(AFTER)
+`,
+			config: LintConfig{"unused-label": SeverityOff},
+			want:   "line 7: error: label \"AFTER\" is not followed by any instruction, jumping to it runs past the program [label-at-end]\n",
+			err:    ErrLintFailed,
+		},
+		"ConfigChangesSeverity": {
+			in: `
+	@DONE
+	D;JEQ
+(DONE)
+`,
+			config: LintConfig{"label-at-end": SeverityWarning},
+			want:   "line 4: warning: label \"DONE\" is not followed by any instruction, jumping to it runs past the program [label-at-end]\n",
+		},
+		"ConfigDisablesRule": {
+			in: `
+(START)
+	@R0
+	D=M
+`,
+			config: LintConfig{"unused-label": SeverityOff},
+			want:   "",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got strings.Builder
+			l := Linter{Config: tc.config}
+			err := l.Lint(strings.NewReader(tc.in), "", &got)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Lint(%q) = %v; want %v", tc.in, err, tc.err)
+			}
+
+			assertDeepEquals(t, "Lint", tc.in, got.String(), tc.want)
+		})
+	}
+
+	t.Run("RejectInvalidProgram", func(t *testing.T) {
+		err := new(Linter).Lint(strings.NewReader("(LOOP"), "", new(strings.Builder))
+		assertError(t, err)
+	})
+}
+
+func TestReadLintConfig(t *testing.T) {
+	in := `
+// generated code uses AM=M-1 on purpose
+a-dest-reads-m off
+label-at-end   warning
+`
+	got, err := ReadLintConfig(strings.NewReader(in), "lint.cfg")
+	assertNoError(t, err)
+
+	want := LintConfig{"a-dest-reads-m": SeverityOff, "label-at-end": SeverityWarning}
+	assertDeepEquals(t, "ReadLintConfig", in, got, want)
+
+	for _, in := range []string{"unused-label", "unknown-rule off", "unused-label fatal", "unused-label off now"} {
+		_, err := ReadLintConfig(strings.NewReader(in), "lint.cfg")
+		assertError(t, err)
+	}
+}
@@ -0,0 +1,361 @@
+package hack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxMacroDepth limits how deep macro invocations can be nested. It guards against macros that
+// invoke themselves.
+const maxMacroDepth = 64
+
+// macro is a named sequence of lines that is expanded in place of every invocation of the macro.
+// It is declared using
+//
+//	.macro NAME param1 param2
+//	...
+//	.endm
+//
+// and invoked using NAME arg1, arg2. Every symbol in the body that matches a parameter is replaced
+// by its argument. Labels declared in the body are renamed so that they are unique per expansion.
+type macro struct {
+	Name   string
+	Params []string
+	Body   []line
+	Pos    pos
+}
+
+// expander expands macro definitions and invocations as well as .rept blocks.
+type expander struct {
+	macros map[string]*macro
+	// constants holds the constants that can be used in the count of .rept blocks.
+	constants  map[string]*constant
+	expansions int
+	// lines counts the lines produced by all expansions.
+	lines int
+}
+
+// expandMacros collects all macro definitions in lines and replaces every macro invocation with the
+// body of the macro. Macros need to be defined before they are invoked. The returned lines do not
+// contain any macro definitions. Every .rept block is replaced by its repeated body. The count of a
+// .rept block can refer to the constants declared in lines and to the constants of the defines.
+func expandMacros(lines []line, defines []instruction) ([]line, error) {
+	e := &expander{macros: make(map[string]*macro), constants: make(map[string]*constant)}
+	for _, ins := range defines {
+		if c, ok := ins.(*constant); ok {
+			e.constants[c.Name] = c
+		}
+	}
+	for _, l := range lines {
+		if d := directive(l); d == ".equ" || d == ".set" {
+			// invalid declarations are reported once the lines are parsed
+			if c, err := parseConstant(l.Command()); err == nil {
+				e.constants[c.Name] = c
+			}
+		}
+	}
+	return e.expand(lines, 0)
+}
+
+func (e *expander) expand(lines []line, depth int) ([]line, error) {
+	var out []line
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		fields := strings.FieldsFunc(l.Command(), isArgSeparator)
+		if len(fields) == 0 {
+			out = append(out, l)
+			continue
+		}
+
+		switch fields[0] {
+		case ".macro":
+			m, err := parseMacro(l, fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			if prev, ok := e.macros[m.Name]; ok {
+				return nil, errorf(l.Pos, "failed to parse macro %q: macro already declared at %s", m.Name, prev.Pos)
+			}
+			end, err := macroEnd(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			m.Body = lines[i+1 : end]
+			e.macros[m.Name] = m
+			i = end
+		case ".endm":
+			return nil, errorf(l.Pos, "failed to parse macro: .endm without .macro")
+		case ".rept":
+			end, err := reptEnd(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			if depth >= maxMacroDepth {
+				return nil, errorf(l.Pos, "failed to expand .rept: exceeded maximum nesting depth of %d", maxMacroDepth)
+			}
+			body, err := e.repeat(l, lines[i+1:end])
+			if err != nil {
+				return nil, err
+			}
+			body, err = e.expand(body, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, body...)
+			i = end
+		case ".endr":
+			return nil, errorf(l.Pos, "failed to parse .endr: .endr without .rept")
+		default:
+			m, ok := e.macros[fields[0]]
+			if !ok {
+				out = append(out, l)
+				continue
+			}
+			if depth >= maxMacroDepth {
+				return nil, errorf(l.Pos, "failed to expand macro %q: exceeded maximum nesting depth of %d. Does the macro invoke itself?", m.Name, maxMacroDepth)
+			}
+			body, err := e.instantiate(m, l.Pos, fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			body, err = e.expand(body, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, body...)
+		}
+	}
+	return out, nil
+}
+
+// isArgSeparator reports whether r separates a macro name from its arguments or the arguments from
+// each other.
+func isArgSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t'
+}
+
+func parseMacro(l line, fields []string) (*macro, error) {
+	if len(fields) == 0 {
+		return nil, errorf(l.Pos, "failed to parse macro: .macro needs to be followed by a name")
+	}
+	m := &macro{Name: fields[0], Pos: l.Pos}
+	if !isSymbol(m.Name) || m.Name[0] == '.' {
+		return nil, errorf(l.Pos, "failed to parse macro %q: name must be a symbol that does not begin with a digit or a dot", m.Name)
+	}
+	seen := make(map[string]bool)
+	for _, param := range fields[1:] {
+		if !isSymbol(param) {
+			return nil, errorf(l.Pos, "failed to parse macro %q: parameter %q must be a symbol", m.Name, param)
+		}
+		if seen[param] {
+			return nil, errorf(l.Pos, "failed to parse macro %q: duplicate parameter %q", m.Name, param)
+		}
+		seen[param] = true
+		m.Params = append(m.Params, param)
+	}
+	return m, nil
+}
+
+// macroEnd returns the index of the .endm closing the macro declared at lines[start].
+func macroEnd(lines []line, start int) (int, error) {
+	for i := start + 1; i < len(lines); i++ {
+		switch directive(lines[i]) {
+		case ".endm":
+			return i, nil
+		case ".macro":
+			return 0, errorf(lines[i].Pos, "failed to parse macro: macros cannot be declared inside of macro declared at %s", lines[start].Pos)
+		}
+	}
+	return 0, errorf(lines[start].Pos, "failed to parse macro: missing .endm")
+}
+
+// instantiate returns the body of macro m with its parameters replaced by given args and its labels
+// renamed so they are unique to this expansion.
+func (e *expander) instantiate(m *macro, call pos, args []string) ([]line, error) {
+	if len(args) != len(m.Params) {
+		return nil, errorf(call, "failed to expand macro %q: expected %d argument(s), got %d", m.Name, len(m.Params), len(args))
+	}
+	if err := e.produce(len(m.Body), call, "macro "+strconv.Quote(m.Name)); err != nil {
+		return nil, err
+	}
+	e.expansions++
+
+	replacements, err := renameLabels(m.Body, "$"+m.Name+"."+strconv.Itoa(e.expansions))
+	if err != nil {
+		return nil, err
+	}
+	for i, param := range m.Params {
+		replacements[param] = args[i]
+	}
+	return substitute(m.Body, replacements, m.Name, call), nil
+}
+
+// renameLabels returns replacements renaming every label declared in body by appending given
+// suffix.
+func renameLabels(body []line, suffix string) (map[string]string, error) {
+	replacements := make(map[string]string)
+	for _, l := range body {
+		name, err := declaredLabel(l)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			replacements[name] = name + suffix
+		}
+	}
+	return replacements, nil
+}
+
+// substitute returns a copy of body with all symbols replaced according to given replacements. The
+// positions of the returned lines record that they were expanded by macro at call.
+func substitute(body []line, replacements map[string]string, macro string, call pos) []line {
+	out := make([]line, len(body))
+	for i, l := range body {
+		p := l.Pos
+		p.Macro = macro
+		p.Call = &call
+		out[i] = line{
+			Text: replaceSymbols(l.Text, func(symbol string) (string, bool) {
+				r, ok := replacements[symbol]
+				return r, ok
+			}),
+			Pos: p,
+		}
+	}
+	return out
+}
+
+// declaredLabel returns the symbol declared by a label on line l or an empty string if the line does
+// not declare a label.
+func declaredLabel(l line) (string, error) {
+	command := l.Command()
+	if len(command) == 0 || command[0] != '(' {
+		return "", nil
+	}
+	lbl, err := parseLabel(command)
+	if err != nil {
+		return "", errorf(l.Pos, "%v", err)
+	}
+	return lbl.Literal, nil
+}
+
+// directive returns the directive on line l like .macro or an empty string if the line does not
+// contain a directive.
+func directive(l line) string {
+	fields := strings.Fields(l.Command())
+	if len(fields) == 0 || fields[0][0] != '.' {
+		return ""
+	}
+	return fields[0]
+}
+
+// maxRepeat limits the number of repetitions of a .rept block to the size of the ROM.
+const maxRepeat = 1 << 15
+
+// maxExpandedLines limits the number of lines produced by all expansions of a program to eight times
+// the size of the ROM. It guards against nested .rept blocks and macros whose expansions grow
+// exponentially.
+const maxExpandedLines = 1 << 18
+
+// constant returns the value of the constant or pre-defined symbol referred to by the count of a
+// .rept block. Labels and variables are not known before macros are expanded. depth counts the
+// constants followed to get to symbol.
+func (e *expander) constant(symbol string, depth int) (int, error) {
+	if v, ok := predefinedSymbols[symbol]; ok {
+		return int(v), nil
+	}
+	c, ok := e.constants[symbol]
+	if !ok {
+		return 0, fmt.Errorf("%q is not a constant declared using .equ", symbol)
+	}
+	if depth > len(e.constants) {
+		return 0, fmt.Errorf("constant %q is defined in terms of itself", symbol)
+	}
+	switch {
+	case c.IsSymbol:
+		return e.constant(c.Literal, depth+1)
+	case c.Expr != nil:
+		return evalExpr(c.Expr, func(symbol string) (int, error) {
+			return e.constant(symbol, depth+1)
+		})
+	}
+	return int(c.Value), nil
+}
+
+// produce counts n lines produced by expanding the directive or macro at position p. It returns an
+// error once all expansions together produced more than maxExpandedLines.
+func (e *expander) produce(n int, p pos, what string) error {
+	e.lines += n
+	if e.lines > maxExpandedLines {
+		return errorf(p, "failed to expand %s: expansions produce more than %d lines", what, maxExpandedLines)
+	}
+	return nil
+}
+
+// reptEnd returns the index of the .endr closing the .rept block started at lines[start]. Blocks can
+// be nested.
+func reptEnd(lines []line, start int) (int, error) {
+	nested := 0
+	for i := start + 1; i < len(lines); i++ {
+		switch directive(lines[i]) {
+		case ".rept":
+			nested++
+		case ".endr":
+			if nested == 0 {
+				return i, nil
+			}
+			nested--
+		}
+	}
+	return 0, errorf(lines[start].Pos, "failed to parse .rept: missing .endr")
+}
+
+// repeat returns the body of the .rept block declared on line l repeated as often as declared. A
+// .rept block is declared using
+//
+//	.rept COUNT [COUNTER]
+//	...
+//	.endr
+//
+// The count is an expression that can refer to constants and pre-defined symbols. Every symbol in
+// the body that matches the optional counter is replaced by the number of the repetition starting at
+// 0 so it can be used in expressions. Labels declared in the body are renamed so that they are unique
+// per repetition.
+func (e *expander) repeat(l line, body []line) ([]line, error) {
+	_, rest := cutField(l.Command())
+	countExpr, counter := cutField(rest)
+	if counter != "" && !isSymbol(counter) {
+		return nil, errorf(l.Pos, "failed to parse .rept: counter %q must be a symbol", counter)
+	}
+	ce, err := parseExpr(countExpr)
+	if err != nil {
+		return nil, errorf(l.Pos, "failed to parse .rept: invalid count %q: %v", countExpr, err)
+	}
+	count, err := evalExpr(ce, func(symbol string) (int, error) {
+		return e.constant(symbol, 0)
+	})
+	if err != nil {
+		return nil, errorf(l.Pos, "failed to parse .rept: count %s: %v", ce, err)
+	}
+	if count < 0 || count > maxRepeat {
+		return nil, errorf(l.Pos, "failed to parse .rept: count %d must be between 0 and %d", count, maxRepeat)
+	}
+
+	var out []line
+	for n := 0; n < count; n++ {
+		if err := e.produce(len(body), l.Pos, ".rept"); err != nil {
+			return nil, err
+		}
+		e.expansions++
+		replacements, err := renameLabels(body, "$rept."+strconv.Itoa(e.expansions))
+		if err != nil {
+			return nil, err
+		}
+		if counter != "" {
+			replacements[counter] = strconv.Itoa(n)
+		}
+		out = append(out, substitute(body, replacements, ".rept", l.Pos)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,368 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandMacros(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want []string
+	}{
+		"MacroWithoutParameters": {
+			in: `
+.macro PUSHD
+	@SP
+	AM=M+1
+	A=A-1
+	M=D
+.endm
+	D=A
+	PUSHD
+`,
+			want: []string{"D=A", "@SP", "AM=M+1", "A=A-1", "M=D"},
+		},
+		"MacroWithParameters": {
+			in: `
+.macro MOV src, dst
+	@src
+	D=M
+	@dst
+	M=D // dst = src
+.endm
+	MOV R0, R1
+	MOV R1 R2
+`,
+			want: []string{"@R0", "D=M", "@R1", "M=D", "@R1", "D=M", "@R2", "M=D"},
+		},
+		"LabelsAreUniquePerExpansion": {
+			in: `
+.macro WAIT
+(LOOP)
+	@LOOP
+	0;JMP
+.endm
+	WAIT
+	WAIT
+`,
+			want: []string{
+				"(LOOP$WAIT.1)", "@LOOP$WAIT.1", "0;JMP",
+				"(LOOP$WAIT.2)", "@LOOP$WAIT.2", "0;JMP",
+			},
+		},
+		"NestedInvocations": {
+			in: `
+.macro SET dst, value
+	@value
+	D=A
+	@dst
+	M=D
+.endm
+.macro CLEAR dst
+	SET dst, 0
+.endm
+	CLEAR R1
+`,
+			want: []string{"@0", "D=A", "@R1", "M=D"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lines, err := readLines(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			got, err := expandMacros(lines, nil)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "expandMacros", tc.in, commands(got), tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingEndm": {
+			in: `
+.macro PUSHD
+	M=D
+`,
+		},
+		"RejectEndmWithoutMacro": {
+			in: `.endm`,
+		},
+		"RejectMacroWithoutName": {
+			in: `
+.macro
+.endm
+`,
+		},
+		"RejectRedeclaration": {
+			in: `
+.macro PUSHD
+.endm
+.macro PUSHD
+.endm
+`,
+		},
+		"RejectNestedDeclaration": {
+			in: `
+.macro OUTER
+.macro INNER
+.endm
+.endm
+`,
+		},
+		"RejectWrongNumberOfArguments": {
+			in: `
+.macro MOV src, dst
+.endm
+	MOV R1
+`,
+		},
+		"RejectRecursion": {
+			in: `
+.macro LOOP
+	LOOP
+.endm
+	LOOP
+`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			lines, err := readLines(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			_, err = expandMacros(lines, nil)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleMacroErrorPointsAtCallSiteAndBody(t *testing.T) {
+	in := `
+.macro BAD
+	D=X
+.endm
+	BAD
+`
+	err := Assemble(strings.NewReader(in), new(strings.Builder))
+	assertError(t, err)
+
+	want := "line 3 (in macro BAD called at line 5)"
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Assemble(%q) = %q; want error starting with %q", in, err, want)
+	}
+}
+
+// commands returns the commands of all non-empty lines.
+func commands(lines []line) []string {
+	var result []string
+	for _, l := range lines {
+		if command := l.Command(); command != "" {
+			result = append(result, command)
+		}
+	}
+	return result
+}
+
+func TestExpandRepeats(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want []string
+	}{
+		"Repeat": {
+			in: `
+.rept 3
+	M=-1
+.endr
+`,
+			want: []string{"M=-1", "M=-1", "M=-1"},
+		},
+		"ZeroRepetitions": {
+			in: `
+.rept 0
+	M=-1
+.endr
+`,
+			want: nil,
+		},
+		"CountReferringToConstants": {
+			in: `
+.rept ROWS-1
+	M=-1
+.endr
+.equ ROWS HALF*2
+.set HALF 1
+`,
+			want: []string{"M=-1", ".equ ROWS HALF*2", ".set HALF 1"},
+		},
+		"CounterInExpressions": {
+			in: `
+.rept 2*2 row
+	@SCREEN+row*32 // row
+.endr
+`,
+			want: []string{"@SCREEN+0*32", "@SCREEN+1*32", "@SCREEN+2*32", "@SCREEN+3*32"},
+		},
+		"Nested": {
+			in: `
+.rept 2 i
+	.rept 2 j
+		@i*2+j
+	.endr
+.endr
+`,
+			want: []string{"@0*2+0", "@0*2+1", "@1*2+0", "@1*2+1"},
+		},
+		"LabelsAreUniquePerRepetition": {
+			in: `
+.rept 2
+(WAIT)
+	@WAIT
+.endr
+`,
+			want: []string{"(WAIT$rept.1)", "@WAIT$rept.1", "(WAIT$rept.2)", "@WAIT$rept.2"},
+		},
+		"InMacro": {
+			in: `
+.macro FILL n
+.rept n
+	M=-1
+.endr
+.endm
+	FILL 2
+`,
+			want: []string{"M=-1", "M=-1"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lines, err := readLines(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			got, err := expandMacros(lines, nil)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "expandMacros", tc.in, commands(got), tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingEndr": {
+			in: ".rept 2\nM=-1",
+		},
+		"RejectEndrWithoutRept": {
+			in: ".endr",
+		},
+		"RejectMissingCount": {
+			in: ".rept\n.endr",
+		},
+		"RejectCountReferringToSymbol": {
+			in: ".rept ROWS\n.endr",
+		},
+		"RejectCountReferringToLabel": {
+			in: "(ROWS)\n.rept ROWS\n.endr",
+		},
+		"RejectCountReferringToConstantDefinedInTermsOfItself": {
+			in: ".equ ROWS ROWS+1\n.rept ROWS\n.endr",
+		},
+		"RejectNegativeCount": {
+			in: ".rept -1\n.endr",
+		},
+		"RejectInvalidCounter": {
+			in: ".rept 2 2i\n.endr",
+		},
+		"RejectNestedRepeatsProducingTooManyLines": {
+			in: ".rept 32768\n.rept 32768\n\tD=D+1\n.endr\n.endr",
+		},
+		"RejectMacrosProducingTooManyLines": {
+			in: `
+.macro a
+	D=D+1
+	D=D+1
+	D=D+1
+	D=D+1
+	D=D+1
+	D=D+1
+	D=D+1
+	D=D+1
+.endm
+.macro b
+	a
+	a
+	a
+	a
+	a
+	a
+	a
+	a
+.endm
+.macro c
+	b
+	b
+	b
+	b
+	b
+	b
+	b
+	b
+.endm
+.macro d
+	c
+	c
+	c
+	c
+	c
+	c
+	c
+	c
+.endm
+.rept 64
+	d
+.endr
+`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			lines, err := readLines(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			_, err = expandMacros(lines, nil)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleRepeatCountReferringToDefine(t *testing.T) {
+	in := ".rept N\n\tD=D+1\n.endr"
+	var got strings.Builder
+	asm := Assembler{Defines: map[string]string{"N": "2"}}
+	err := asm.Assemble(strings.NewReader(in), &got)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Assemble", in, got.String(), "1110011111010000\n1110011111010000\n")
+}
+
+func TestAssembleRepeatErrorPointsAtBody(t *testing.T) {
+	in := `
+.rept 2
+	D=X
+.endr
+`
+	err := Assemble(strings.NewReader(in), new(strings.Builder))
+	assertError(t, err)
+
+	want := "line 3 (in .rept at line 2)"
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Assemble(%q) = %q; want error starting with %q", in, err, want)
+	}
+}
@@ -0,0 +1,452 @@
+package hack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// objectFormat identifies the format of object files so that objects written by an incompatible
+// version of the assembler are rejected.
+const objectFormat = "hack-object/1"
+
+// Object is a relocatable object file produced by assembling a single program without resolving
+// its symbols. It holds the encoded instructions, relocation records for A-instructions that refer
+// to symbols and the declarations of labels, constants and variables. Objects are linked into a
+// program using Assembler.Link.
+//
+// Labels declared by the user are exported and can be referenced by other objects. Constants and
+// labels generated by macros, .rept or control-flow directives are private to the object. Variables
+// are shared by all objects like they are shared by all files of a program today.
+type Object struct {
+	// Name is the name of the object file used in errors. It is not part of the object file.
+	Name        string            `json:"-"`
+	Format      string            `json:"format"`
+	Source      string            `json:"source,omitempty"`
+	Words       []objectWord      `json:"words"`
+	Relocations []relocation      `json:"relocations,omitempty"`
+	Directives  []objectDirective `json:"directives,omitempty"`
+	// Suppressions are the warnings suppressed by comments in the source so they are suppressed
+	// once the object is linked.
+	Suppressions []suppression `json:"suppressions,omitempty"`
+}
+
+// objectWord is an encoded instruction. Words of A-instructions that refer to symbols are 0 until
+// they are relocated.
+type objectWord struct {
+	Code uint16 `json:"code"`
+	Text string `json:"text"`
+	Pos  pos    `json:"pos"`
+}
+
+// relocation records that the word at Offset is an A-instruction loading the value of Expr which can
+// only be evaluated once the object is linked.
+type relocation struct {
+	Offset int    `json:"offset"`
+	Expr   string `json:"expr"`
+}
+
+// objectDirective is a label, constant, variable, block, placement or entry point declared before
+// the word at Offset. Kind is "label" or the directive like .equ or .var. Value holds the expression
+// of constants, the address of variables, the size of blocks, the value of placements or the
+// initializations of the entry point.
+type objectDirective struct {
+	Offset   int    `json:"offset"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Exported bool   `json:"exported,omitempty"`
+	Pos      pos    `json:"pos"`
+}
+
+// word is an instruction that has already been encoded into machine code.
+type word struct {
+	Code uint16
+	Text string
+	Pos  pos
+}
+
+func (w word) Instruction() {}
+
+func (w word) String() string {
+	return w.Text
+}
+
+// Compile translates hack assembly read from r into a relocatable object written to w. Symbols are
+// resolved when the object is linked.
+func (a *Assembler) Compile(r io.Reader, w io.Writer) error {
+	instructions, err := a.parse(r, "")
+	if err != nil {
+		return err
+	}
+	return a.compile(instructions, "", w)
+}
+
+// CompileFile translates the hack assembly in file name of a.FS into a relocatable object written to
+// w.
+func (a *Assembler) CompileFile(name string, w io.Writer) error {
+	if a.FS == nil {
+		return fmt.Errorf("failed to open %q: no file system to read from", name)
+	}
+	f, err := a.FS.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	instructions, err := a.parse(f, name)
+	if err != nil {
+		return err
+	}
+	return a.compile(instructions, name, w)
+}
+
+// compile writes instructions as an object to w.
+func (a *Assembler) compile(instructions []instruction, source string, w io.Writer) error {
+	o, err := newObject(instructions, source)
+	if err != nil {
+		return err
+	}
+	o.Suppressions = a.suppressions
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(o)
+}
+
+// newObject encodes instructions into an object. Instructions that do not refer to symbols are
+// encoded right away.
+func newObject(instructions []instruction, source string) (*Object, error) {
+	o := &Object{Format: objectFormat, Source: source}
+	directive := func(kind, name, value string, p pos) {
+		o.Directives = append(o.Directives, objectDirective{Offset: len(o.Words), Kind: kind, Name: name, Value: value, Pos: p})
+	}
+
+	for _, instruction := range instructions {
+		switch ins := instruction.(type) {
+		case *label:
+			directive("label", ins.Literal, "", ins.Pos)
+			o.Directives[len(o.Directives)-1].Exported = !ins.Generated && ins.Pos.Call == nil
+		case *constant:
+			value := operandExpr(&aInstruction{Literal: ins.Literal, IsSymbol: ins.IsSymbol, Value: ins.Value, Expr: ins.Expr})
+			directive(".equ", ins.Name, value.String(), ins.Pos)
+		case *variable:
+			var address string
+			if ins.Address != nil {
+				address = ins.Address.String()
+			}
+			directive(".var", ins.Name, address, ins.Pos)
+		case *array:
+			directive(".block", ins.Name, ins.Size.String(), ins.Pos)
+		case *placement:
+			directive(ins.Directive, "", strconv.Itoa(int(ins.Value)), ins.Pos)
+		case *entry:
+			directive(".entry", ins.Label, strings.Join(ins.Init, " "), ins.Pos)
+		case *aInstruction:
+			if ins.IsSymbol || ins.Expr != nil {
+				o.Relocations = append(o.Relocations, relocation{Offset: len(o.Words), Expr: operandExpr(ins).String()})
+			}
+			o.Words = append(o.Words, objectWord{Code: ins.Value, Text: ins.String(), Pos: ins.Pos})
+		case *cInstruction:
+			code, err := codeCInstruction(ins)
+			if err != nil {
+				return nil, errorf(ins.Pos, "failed to encode c-instruction %q: %v", ins, err)
+			}
+			v, err := strconv.ParseUint(string(code), 2, 16)
+			if err != nil {
+				return nil, errorf(ins.Pos, "failed to encode c-instruction %q: %v", ins, err)
+			}
+			o.Words = append(o.Words, objectWord{Code: uint16(v), Text: ins.String(), Pos: ins.Pos})
+		}
+	}
+	return o, nil
+}
+
+// ReadObject reads an object written by Compile. The name of the object is used in errors.
+func ReadObject(r io.Reader, name string) (*Object, error) {
+	var o Object
+	if err := json.NewDecoder(r).Decode(&o); err != nil {
+		return nil, fmt.Errorf("failed to read object %q: %v", name, err)
+	}
+	o.Name = name
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// check returns an error if the object is not well-formed.
+func (o *Object) check() error {
+	if o.Format != objectFormat {
+		return fmt.Errorf("failed to read object %q: expected format %q instead got %q", o.Name, objectFormat, o.Format)
+	}
+	for _, r := range o.Relocations {
+		if r.Offset < 0 || r.Offset >= len(o.Words) {
+			return fmt.Errorf("failed to read object %q: relocation at offset %d is outside of the object", o.Name, r.Offset)
+		}
+	}
+	for _, d := range o.Directives {
+		switch d.Kind {
+		case ".equ", ".block", ".org", ".align":
+			if strings.TrimSpace(d.Value) == "" {
+				return errorf(d.Pos, "failed to read object %q: %s directive is missing its value", o.Name, d.Kind)
+			}
+		}
+	}
+	return nil
+}
+
+// exports returns the labels exported by the object.
+func (o *Object) exports() []string {
+	var exports []string
+	for _, d := range o.Directives {
+		if d.Kind == "label" && d.Exported {
+			exports = append(exports, d.Name)
+		}
+	}
+	return exports
+}
+
+// references returns the symbols referred to by A-instructions of the object that are not declared
+// by the object itself.
+func (o *Object) references() ([]string, error) {
+	declared := make(map[string]bool)
+	for _, d := range o.Directives {
+		declared[d.Name] = true
+	}
+
+	var references []string
+	for _, r := range o.Relocations {
+		e, err := parseExpr(r.Expr)
+		if err != nil {
+			return nil, errorf(o.Words[r.Offset].Pos, "failed to read object %q: %v", o.Name, err)
+		}
+		mapSymbols(e, func(symbol string) string {
+			if !declared[symbol] {
+				references = append(references, symbol)
+			}
+			return symbol
+		})
+	}
+	return references, nil
+}
+
+// Link links objects into a program written to w as machine code. The objects are placed in ROM in
+// the given order followed by the members of the archives that are needed to resolve the labels the
+// objects refer to. Exported labels are resolved across objects and variables are allocated from
+// address 16 like Assemble does. Undefined symbols that are jumped to or differ from a label only in
+// case are rejected as misspelled labels. Listing, Symbols, MemoryMap, Strict and Entry apply like
+// they do for Assemble.
+func (a *Assembler) Link(objects []*Object, w io.Writer, archives ...*Archive) error {
+	objects, err := selectMembers(objects, archives)
+	if err != nil {
+		return err
+	}
+
+	exported := make(map[string]objectDirective)
+	exporter := make(map[string]*Object)
+	for _, o := range objects {
+		for _, d := range o.Directives {
+			if d.Kind != "label" || !d.Exported {
+				continue
+			}
+			if prev, ok := exported[d.Name]; ok {
+				return fmt.Errorf("failed to link: duplicate symbol %q exported by %s at %s and by %s at %s", d.Name, exporter[d.Name].Name, prev.Pos, o.Name, d.Pos)
+			}
+			exported[d.Name] = d
+			exporter[d.Name] = o
+		}
+	}
+
+	var instructions []instruction
+	a.suppressions = nil
+	for _, o := range objects {
+		ins, err := o.instructions()
+		if err != nil {
+			return err
+		}
+		instructions = append(instructions, ins...)
+		a.suppressions = append(a.suppressions, o.Suppressions...)
+	}
+	// the standard library is included before so that references to it are not undefined
+	instructions, err = includeStd(instructions)
+	if err != nil {
+		return err
+	}
+	if err := checkUndefined(objects, instructions, a.Strict); err != nil {
+		return err
+	}
+	return a.assemble(instructions, w)
+}
+
+// private renames the private symbol to NAME$OBJECT so that it does not clash with the private
+// symbols of other objects. Characters of the object name that are not valid in symbols like the
+// path separator are replaced by an underscore and closing parentheses of archive members are
+// dropped so that lib/a.o becomes lib_a.o and lib.a(mul.o) becomes lib.a_mul.o.
+func (o *Object) private(symbol string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == ')' {
+			return -1
+		}
+		if !validSymbolChars(r) {
+			return '_'
+		}
+		return r
+	}, o.Name)
+	return symbol + "$" + name
+}
+
+// instructions decodes the object into instructions. Private symbols are renamed using private.
+func (o *Object) instructions() ([]instruction, error) {
+	private := make(map[string]bool)
+	for _, d := range o.Directives {
+		if (d.Kind == "label" && !d.Exported) || d.Kind == ".equ" {
+			private[d.Name] = true
+		}
+	}
+	rename := func(symbol string) string {
+		if private[symbol] {
+			return o.private(symbol)
+		}
+		return symbol
+	}
+	parse := func(s string, p pos) (expr, error) {
+		e, err := parseExpr(s)
+		if err != nil {
+			return nil, errorf(p, "failed to read object %q: %v", o.Name, err)
+		}
+		return mapSymbols(e, rename), nil
+	}
+
+	relocations := make(map[int]string, len(o.Relocations))
+	for _, r := range o.Relocations {
+		relocations[r.Offset] = r.Expr
+	}
+
+	var out []instruction
+	directives := o.Directives
+	for offset := 0; offset <= len(o.Words); offset++ {
+		for len(directives) > 0 && directives[0].Offset == offset {
+			d := directives[0]
+			directives = directives[1:]
+
+			var ins instruction
+			var value expr
+			if d.Value != "" && d.Kind != ".entry" {
+				e, err := parse(d.Value, d.Pos)
+				if err != nil {
+					return nil, err
+				}
+				value = e
+			}
+			switch d.Kind {
+			case "label":
+				ins = &label{Literal: rename(d.Name), Pos: d.Pos}
+			case ".equ":
+				ins = &constant{Name: rename(d.Name), Literal: value.String(), Expr: value, Pos: d.Pos}
+			case ".var":
+				ins = &variable{Name: d.Name, Address: value, Pos: d.Pos}
+			case ".block":
+				ins = &array{Name: d.Name, Size: value, Pos: d.Pos}
+			case ".org", ".align":
+				v, err := evalExpr(value, nil)
+				if err != nil {
+					return nil, errorf(d.Pos, "failed to read object %q: %v", o.Name, err)
+				}
+				ins = &placement{Directive: d.Kind, Value: uint16(v), Pos: d.Pos}
+			case ".entry":
+				ins = &entry{Label: rename(d.Name), Init: strings.Fields(d.Value), Pos: d.Pos}
+			default:
+				return nil, errorf(d.Pos, "failed to read object %q: unknown directive %q", o.Name, d.Kind)
+			}
+			out = append(out, ins)
+		}
+		if offset == len(o.Words) {
+			break
+		}
+
+		w := o.Words[offset]
+		s, ok := relocations[offset]
+		if !ok {
+			out = append(out, &word{Code: w.Code, Text: w.Text, Pos: w.Pos})
+			continue
+		}
+		e, err := parse(s, w.Pos)
+		if err != nil {
+			return nil, err
+		}
+		if symbol, ok := e.(symbolExpr); ok {
+			out = append(out, &aInstruction{Literal: symbol.Name, IsSymbol: true, Pos: w.Pos})
+		} else {
+			out = append(out, &aInstruction{Literal: e.String(), Expr: e, Pos: w.Pos})
+		}
+	}
+	if len(directives) > 0 {
+		return nil, errorf(directives[0].Pos, "failed to read object %q: directive at offset %d is outside of the object", o.Name, directives[0].Offset)
+	}
+	return out, nil
+}
+
+// checkUndefined returns an error naming the object and source position of the first reference to
+// a symbol that is neither pre-defined nor declared by any of the objects. Unless strict, such
+// symbols are allocated as variables and only reported if they are likely misspelled labels: if they
+// differ from a label only in case or if they are jumped to.
+func checkUndefined(objects []*Object, instructions []instruction, strict bool) error {
+	declared := make(map[string]bool)
+	// labels maps the lower case name of every label to the label
+	labels := make(map[string]string)
+	for _, ins := range instructions {
+		switch ins := ins.(type) {
+		case *label:
+			declared[ins.Literal] = true
+			labels[strings.ToLower(ins.Literal)] = ins.Literal
+		case *constant:
+			declared[ins.Name] = true
+		case *variable:
+			declared[ins.Name] = true
+		case *array:
+			declared[ins.Name] = true
+		}
+	}
+
+	for _, o := range objects {
+		for _, r := range o.Relocations {
+			e, err := parseExpr(r.Expr)
+			if err != nil {
+				return err
+			}
+			var undefined string
+			mapSymbols(e, func(symbol string) string {
+				_, predefined := predefinedSymbols[symbol]
+				if undefined == "" && !predefined && !declared[symbol] && !declared[o.private(symbol)] {
+					undefined = symbol
+				}
+				return symbol
+			})
+			if undefined == "" {
+				continue
+			}
+			p := o.Words[r.Offset].Pos
+			if strict {
+				return fmt.Errorf("failed to link: undefined symbol %q referenced by %s at %s", undefined, o.Name, p)
+			}
+			if label, ok := labels[strings.ToLower(undefined)]; ok {
+				return fmt.Errorf("failed to link: undefined symbol %q referenced by %s at %s, did you mean label %q?", undefined, o.Name, p, label)
+			}
+			if o.jumpsAfter(r.Offset) {
+				return fmt.Errorf("failed to link: undefined label %q jumped to by %s at %s", undefined, o.Name, p)
+			}
+		}
+	}
+	return nil
+}
+
+// jumpsAfter returns true if the word following the one at offset is a C-instruction that jumps.
+func (o *Object) jumpsAfter(offset int) bool {
+	if offset+1 >= len(o.Words) {
+		return false
+	}
+	code := o.Words[offset+1].Code
+	return code&0x8000 != 0 && code&0x7 != 0
+}
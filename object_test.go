@@ -0,0 +1,301 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	main := `
+.equ N 3
+	@N
+	D=A
+	@count
+	M=D
+	@MUL
+	0;JMP
+(END)
+	@END
+	0;JMP
+`
+	mul := `
+.equ N 1
+.var product
+(MUL)
+	.while D != 0
+		@N
+		D=D-A
+	.endw
+	@product
+	M=D
+	@count
+	@END
+	0;JMP
+`
+	lib := `
+(WAIT)
+	.while D != 0
+		D=D-1
+	.endw
+`
+
+	objects := []*Object{
+		compile(t, "main.o", main),
+		compile(t, "mul.o", mul),
+		compile(t, "lib.o", lib),
+	}
+	var got bytes.Buffer
+	var symbols strings.Builder
+	asm := Assembler{Symbols: &symbols}
+	err := asm.Link(objects, &got)
+	assertNoError(t, err)
+
+	want := `0000000000000011
+1110110000010000
+0000000000010001
+1110001100001000
+0000000000001000
+1110101010000111
+0000000000000110
+1110101010000111
+0000000000001110
+1110001100000010
+0000000000000001
+1110010011010000
+0000000000001000
+1110101010000111
+0000000000010000
+1110001100001000
+0000000000010001
+0000000000000110
+1110101010000111
+0000000000011000
+1110001100000010
+1110001110010000
+0000000000010011
+1110101010000111
+`
+	assertDeepEquals(t, "Link", main+mul+lib, got.String(), want)
+
+	wantSymbols := `END                6   label
+MUL                8   label
+WHILE_1$mul.o      8   label
+WHILE_1_END$mul.o  14  label
+WAIT               19  label
+WHILE_1$lib.o      19  label
+WHILE_1_END$lib.o  24  label
+N$main.o           3   constant
+N$mul.o            1   constant
+product            16  variable
+count              17  variable
+`
+	assertDeepEquals(t, "Link", main+mul+lib, symbols.String(), wantSymbols)
+}
+
+func TestLinkRenamesPrivateSymbolsToValidSymbols(t *testing.T) {
+	objects := []*Object{
+		compile(t, "lib/a.o", ".equ K 1\n\t@K"),
+		compile(t, "lib.a(mul.o)", ".equ X 2\n\t@X"),
+	}
+	var symbols strings.Builder
+	asm := Assembler{Symbols: &symbols}
+	err := asm.Link(objects, new(strings.Builder))
+	assertNoError(t, err)
+
+	want := `K$lib_a.o      1  constant
+X$lib.a_mul.o  2  constant
+`
+	assertDeepEquals(t, "Link", "", symbols.String(), want)
+	for _, line := range strings.Split(strings.TrimSpace(symbols.String()), "\n") {
+		if symbol := strings.Fields(line)[0]; !isSymbol(symbol) {
+			t.Errorf("Link() renamed private symbol to %q which is not a valid symbol", symbol)
+		}
+	}
+}
+
+func TestLinkDropsUnreachableCode(t *testing.T) {
+	tests := map[string]string{
+		"DropCodeAfterUnconditionalJump": `
+	@END
+	0;JMP
+	D=0
+	D=1
+(END)
+	@END
+	0;JMP
+`,
+		"KeepCodeIfJumpingToNumbers": `
+	@6
+	0;JMP
+	D=0
+	D=1
+	D=0
+	D=1
+	@R0
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+		"KeepCodeReachedThroughROMAddressStoredInRAM": `
+	@9
+	D=A
+	@R13
+	M=D
+	@R13
+	A=M
+	0;JMP
+	D=0
+	D=1
+	@R0
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+	}
+
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got strings.Builder
+			err := (&Assembler{DropUnreachable: true}).Link([]*Object{compile(t, "main.o", in)}, &got)
+			assertNoError(t, err)
+
+			var want strings.Builder
+			err = (&Assembler{DropUnreachable: true}).Assemble(strings.NewReader(in), &want)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Link", in, got.String(), want.String())
+		})
+	}
+}
+
+func TestLinkOptimizes(t *testing.T) {
+	tests := map[string]string{
+		"OptimizeLinkedCode": `
+	@R1
+	@R2
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+		"JumpToNumber": `
+	@R1
+	@R2
+	M=1
+	@6
+	0;JMP
+	D=0
+	@R0
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+	}
+
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got strings.Builder
+			err := (&Assembler{Optimize: true}).Link([]*Object{compile(t, "main.o", in)}, &got)
+			assertNoError(t, err)
+
+			var want strings.Builder
+			err = (&Assembler{Optimize: true}).Assemble(strings.NewReader(in), &want)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Link", in, got.String(), want.String())
+		})
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	t.Run("RejectDuplicateExportedLabels", func(t *testing.T) {
+		objects := []*Object{
+			compile(t, "a.o", "(LOOP)\n\t0;JMP"),
+			compile(t, "b.o", "\n(LOOP)\n\t0;JMP"),
+		}
+		err := new(Assembler).Link(objects, new(strings.Builder))
+		assertError(t, err)
+
+		want := `failed to link: duplicate symbol "LOOP" exported by a.o at line 1 and by b.o at line 2`
+		assertDeepEquals(t, "Link", "", err.Error(), want)
+	})
+
+	t.Run("RejectUndefinedSymbolInStrictMode", func(t *testing.T) {
+		objects := []*Object{
+			compile(t, "a.o", "(A)\n\t@A"),
+			compile(t, "b.o", "\n\t@MISSING\n\t@A"),
+		}
+		err := (&Assembler{Strict: true}).Link(objects, new(strings.Builder))
+		assertError(t, err)
+
+		want := `failed to link: undefined symbol "MISSING" referenced by b.o at line 2`
+		assertDeepEquals(t, "Link", "", err.Error(), want)
+	})
+
+	t.Run("RejectUndefinedJumpTarget", func(t *testing.T) {
+		objects := []*Object{
+			compile(t, "main.o", "\t@Mul\n\t0;JMP"),
+			compile(t, "mul.o", "(MUL)\n\t@MUL"),
+		}
+		err := new(Assembler).Link(objects[:1], new(strings.Builder))
+		assertError(t, err)
+
+		want := `failed to link: undefined label "Mul" jumped to by main.o at line 1`
+		assertDeepEquals(t, "Link", "", err.Error(), want)
+
+		err = new(Assembler).Link(objects, new(strings.Builder))
+		assertError(t, err)
+
+		want = `failed to link: undefined symbol "Mul" referenced by main.o at line 1, did you mean label "MUL"?`
+		assertDeepEquals(t, "Link", "", err.Error(), want)
+	})
+
+	t.Run("RejectPrivateSymbolOfOtherObject", func(t *testing.T) {
+		objects := []*Object{
+			compile(t, "a.o", ".equ N 1"),
+			compile(t, "b.o", "@N+1"),
+		}
+		err := new(Assembler).Link(objects, new(strings.Builder))
+		assertError(t, err)
+	})
+}
+
+func TestReadObjectRejectsDirectivesWithoutValue(t *testing.T) {
+	tests := map[string]string{
+		"Constant": `{"format": "hack-object/1", "words": [], "directives": [{"offset": 0, "kind": ".equ", "name": "N", "pos": {"line": 3}}]}`,
+		"Block":    `{"format": "hack-object/1", "words": [], "directives": [{"offset": 0, "kind": ".block", "name": "buf", "value": " ", "pos": {"line": 3}}]}`,
+	}
+
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadObject(strings.NewReader(in), "a.o")
+			assertError(t, err)
+
+			if !strings.HasPrefix(err.Error(), "line 3: ") {
+				t.Errorf("ReadObject() = %q, want error at line 3", err)
+			}
+		})
+	}
+}
+
+func TestReadObjectRejectsUnknownFormat(t *testing.T) {
+	_, err := ReadObject(strings.NewReader(`{"format": "hack-object/0", "words": []}`), "a.o")
+	assertError(t, err)
+}
+
+// compile compiles the hack assembly in into an object and reads it back.
+func compile(t *testing.T, name, in string) *Object {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := new(Assembler).Compile(strings.NewReader(in), &buf)
+	assertNoError(t, err)
+
+	o, err := ReadObject(&buf, name)
+	assertNoError(t, err)
+	return o
+}
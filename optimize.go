@@ -0,0 +1,413 @@
+package hack
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// rewrite is a peephole optimization. It looks at the instructions starting at index i and returns
+// the number of instructions it matched together with their replacement. A rewrite that does not
+// apply returns 0.
+type rewrite struct {
+	Name  string
+	Apply func(instructions []instruction, i int) (int, []instruction)
+}
+
+// rewrites is the catalogue of peephole optimizations. Every rewrite preserves the values written
+// to RAM and the jumps taken. Rewrites only look at straight-line code that is not the target of a
+// label unless stated otherwise.
+var rewrites = []rewrite{
+	{Name: "redundant-load", Apply: redundantLoad},
+	{Name: "overwritten-load", Apply: overwrittenLoad},
+	{Name: "redundant-copy", Apply: redundantCopy},
+	{Name: "jump-to-next", Apply: jumpToNext},
+	{Name: "constant-load", Apply: constantLoad},
+}
+
+// optimization counts the instructions saved by each rewrite.
+type optimization struct {
+	Before int
+	After  int
+	Saved  map[string]int
+}
+
+// optimize applies the rewrites until none of them applies anymore. Labels are resolved once the
+// instructions have been translated into machine code so they account for removed instructions.
+// Programs computing ROM addresses from labels like @LOOP+2 are not optimized as removing
+// instructions would change what such an address refers to. For the same reason jumps to ROM
+// addresses given as numbers are pinned to their target and code before a number an indirect jump
+// might go to is not optimized.
+func (a *Assembler) optimize(instructions []instruction) ([]instruction, *optimization) {
+	result := &optimization{Before: countCode(instructions), Saved: make(map[string]int)}
+	if p, ok := labelArithmetic(instructions); ok {
+		a.warnf(p, warnNotOptimized, "program is not optimized as it computes a ROM address from a label")
+		result.After = result.Before
+		return instructions, result
+	}
+	instructions = pinJumpTargets(instructions)
+
+	// code before a number an indirect jump might go to is kept in place so the number keeps
+	// referring to the same instruction
+	var first int
+	addresses, size := romAddresses(instructions)
+	if pinned, p := pinnedAddress(instructions, nil, size); pinned > 0 {
+		a.warnf(p, warnNotOptimized, "code before ROM address %d is not optimized as the program might jump to the number loaded here indirectly", pinned)
+		for first < len(instructions) && addresses[first] < pinned {
+			first++
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := first; i < len(instructions); i++ {
+			for _, r := range rewrites {
+				n, replacement := r.Apply(instructions, i)
+				if n == 0 {
+					continue
+				}
+				result.Saved[r.Name] += n - len(replacement)
+				instructions = append(instructions[:i:i], append(replacement, instructions[i+n:]...)...)
+				changed = true
+				break
+			}
+		}
+	}
+	result.After = countCode(instructions)
+	return instructions, result
+}
+
+// pinJumpTargets replaces every ROM address given as number that is jumped to like @95 0;JMP by a
+// generated label like ROM$95 declared before the instruction at that address. The jump thus keeps
+// going to the same instruction once code before it is removed. Addresses outside of the program are
+// kept.
+func pinJumpTargets(instructions []instruction) []instruction {
+	addresses, _ := romAddresses(instructions)
+	targets := make(map[int]int)
+	for i, ins := range instructions {
+		if isCode(ins) {
+			targets[addresses[i]] = i
+		}
+	}
+
+	declared := declaredSymbols(instructions)
+	// labels holds the generated label of every instruction that is jumped to
+	labels := make(map[int]string)
+	target := func(i int) (int, bool) {
+		value, ok := numericLoad(instructions[i])
+		if !ok || !isAbsoluteJump(instructions, i) {
+			return 0, false
+		}
+		t, ok := targets[int(value)]
+		return t, ok
+	}
+	for i := range instructions {
+		t, ok := target(i)
+		if !ok || labels[t] != "" {
+			continue
+		}
+		name := fmt.Sprintf("ROM$%d", addresses[t])
+		for declared[name] {
+			name += "$"
+		}
+		labels[t] = name
+	}
+	if len(labels) == 0 {
+		return instructions
+	}
+
+	pinned := make([]instruction, 0, len(instructions)+len(labels))
+	for i, ins := range instructions {
+		if name, ok := labels[i]; ok {
+			pinned = append(pinned, &label{Literal: name, Generated: true, Pos: codePos(ins)})
+		}
+		if t, ok := target(i); ok {
+			ins = &aInstruction{Literal: labels[t], IsSymbol: true, Pos: codePos(ins)}
+		}
+		pinned = append(pinned, ins)
+	}
+	return pinned
+}
+
+// write writes the instructions saved by each rewrite and in total to w.
+func (o *optimization) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, r := range rewrites {
+		if o.Saved[r.Name] > 0 {
+			fmt.Fprintf(tw, "%s\t%d\n", r.Name, o.Saved[r.Name])
+		}
+	}
+	fmt.Fprintf(tw, "saved %d of %d instructions\n", o.Before-o.After, o.Before)
+	return tw.Flush()
+}
+
+// redundantLoad removes an A-instruction loading the value A already holds
+//
+//	@x        @x
+//	D=M   =>  D=M
+//	@x
+func redundantLoad(instructions []instruction, i int) (int, []instruction) {
+	load, ok := instructions[i].(*aInstruction)
+	if !ok {
+		return 0, nil
+	}
+	var kept []instruction
+	for _, ins := range instructions[i+1:] {
+		switch ins := ins.(type) {
+		case *aInstruction:
+			if ins.String() != load.String() {
+				return 0, nil
+			}
+			return len(kept) + 2, append([]instruction{load}, kept...)
+		case *cInstruction:
+			if strings.Contains(ins.Dest, "A") {
+				return 0, nil
+			}
+			kept = append(kept, ins)
+		default:
+			return 0, nil
+		}
+	}
+	return 0, nil
+}
+
+// overwrittenLoad removes an A-instruction that is immediately followed by another one
+//
+//	@x
+//	@y    =>  @y
+//
+// The first reference to a variable is kept. Removing the first reference to a symbol that is not
+// declared would move every variable allocated after it and removing the first reference to a
+// variable declared using .var or .block might leave it unused.
+func overwrittenLoad(instructions []instruction, i int) (int, []instruction) {
+	if i+1 >= len(instructions) {
+		return 0, nil
+	}
+	_, ok := instructions[i].(*aInstruction)
+	if !ok || referencesVariableFirst(instructions, i) {
+		return 0, nil
+	}
+	next, ok := instructions[i+1].(*aInstruction)
+	if !ok {
+		return 0, nil
+	}
+	return 2, []instruction{next}
+}
+
+// referencesVariableFirst returns true if the A-instruction at index i is the first A-instruction
+// referring to a variable, either declared using .var or .block or allocated for a symbol that is
+// neither pre-defined nor declared.
+func referencesVariableFirst(instructions []instruction, i int) bool {
+	load, ok := instructions[i].(*aInstruction)
+	if !ok || (!load.IsSymbol && load.Expr == nil) {
+		return false
+	}
+	declared := declaredSymbols(instructions)
+	variables := make(map[string]bool)
+	referenced := make(map[string]bool)
+	for j, ins := range instructions {
+		switch ins := ins.(type) {
+		case *variable:
+			variables[ins.Name] = true
+		case *array:
+			variables[ins.Name] = true
+		case *aInstruction:
+			if j < i && (ins.IsSymbol || ins.Expr != nil) {
+				mapSymbols(operandExpr(ins), func(symbol string) string {
+					referenced[symbol] = true
+					return symbol
+				})
+			}
+		}
+	}
+
+	var first bool
+	mapSymbols(operandExpr(load), func(symbol string) string {
+		_, predefined := predefinedSymbols[symbol]
+		isVariable := variables[symbol] || (!declared[symbol] && !predefined)
+		first = first || (isVariable && !referenced[symbol])
+		return symbol
+	})
+	return first
+}
+
+// redundantCopy removes the second of two C-instructions copying a register back to where it was
+// copied from
+//
+//	D=A       D=A
+//	A=D   =>
+//
+// Copies between A and M are left as writing A changes which word M refers to.
+func redundantCopy(instructions []instruction, i int) (int, []instruction) {
+	if i+1 >= len(instructions) {
+		return 0, nil
+	}
+	first, ok := instructions[i].(*cInstruction)
+	if !ok || first.Jump != "" {
+		return 0, nil
+	}
+	second, ok := instructions[i+1].(*cInstruction)
+	if !ok || second.Jump != "" {
+		return 0, nil
+	}
+	if !isRegister(first.Dest) || !isRegister(first.Comp) || first.Dest == first.Comp {
+		return 0, nil
+	}
+	if first.Dest+first.Comp == "AM" || first.Dest+first.Comp == "MA" {
+		return 0, nil
+	}
+	if second.Dest != first.Comp || second.Comp != first.Dest {
+		return 0, nil
+	}
+	return 2, []instruction{first}
+}
+
+func isRegister(s string) bool {
+	return s == "A" || s == "D" || s == "M"
+}
+
+// jumpToNext removes a jump to the instruction following it
+//
+//	@NEXT
+//	D;JGT     =>  (NEXT)
+//	(NEXT)        @x
+//	@x
+//
+// The jump needs to be without dest as its computation would otherwise be lost. A is only
+// overwritten by the jump if the instruction at the label loads a new value into A.
+func jumpToNext(instructions []instruction, i int) (int, []instruction) {
+	if i+2 >= len(instructions) {
+		return 0, nil
+	}
+	load, ok := instructions[i].(*aInstruction)
+	if !ok || !load.IsSymbol || load.Expr != nil {
+		return 0, nil
+	}
+	jump, ok := instructions[i+1].(*cInstruction)
+	if !ok || jump.Jump == "" || jump.Dest != "" {
+		return 0, nil
+	}
+	for _, ins := range instructions[i+2:] {
+		l, ok := ins.(*label)
+		if !ok {
+			return 0, nil
+		}
+		if l.Literal == load.Literal {
+			break
+		}
+	}
+	if !loadsNext(instructions, i+2) {
+		return 0, nil
+	}
+	return 2, nil
+}
+
+// constantLoad replaces loading the constant 0 or 1 into D by the corresponding computation
+//
+//	@1
+//	D=A   =>  D=1
+//	@x        @x
+func constantLoad(instructions []instruction, i int) (int, []instruction) {
+	if i+1 >= len(instructions) {
+		return 0, nil
+	}
+	load, ok := instructions[i].(*aInstruction)
+	if !ok || load.IsSymbol || load.Expr != nil || load.Value > 1 {
+		return 0, nil
+	}
+	c, ok := instructions[i+1].(*cInstruction)
+	if !ok || c.Dest != "D" || c.Comp != "A" || c.Jump != "" {
+		return 0, nil
+	}
+	if !loadsNext(instructions, i+2) {
+		return 0, nil
+	}
+	return 2, []instruction{&cInstruction{Dest: "D", Comp: fmt.Sprint(load.Value), Pos: c.Pos}}
+}
+
+// loadsNext returns true if the next instruction translated into machine code starting at index i
+// is an A-instruction. The value of A before it can thus not be observed.
+func loadsNext(instructions []instruction, i int) bool {
+	for _, ins := range instructions[i:] {
+		switch ins.(type) {
+		case *aInstruction:
+			return true
+		case *label, *constant, *variable, *array:
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// labelArithmetic returns the position of the first A-instruction or constant computing an address
+// from a label in an expression like @LOOP+2 or .equ NEXT HANDLER+1 where HANDLER is a constant
+// defined as a label.
+func labelArithmetic(instructions []instruction) (pos, bool) {
+	aliases := labelAliases(instructions)
+	refersToLabel := func(e expr) bool {
+		var found bool
+		mapSymbols(e, func(symbol string) string {
+			_, ok := aliases[symbol]
+			found = found || ok
+			return symbol
+		})
+		return found
+	}
+	for _, ins := range instructions {
+		switch ins := ins.(type) {
+		case *aInstruction:
+			if ins.Expr != nil && refersToLabel(ins.Expr) {
+				return ins.Pos, true
+			}
+		case *constant:
+			if ins.Expr != nil && refersToLabel(ins.Expr) {
+				return ins.Pos, true
+			}
+		}
+	}
+	return pos{}, false
+}
+
+// labelAliases returns the label every symbol refers to that is either a label or a constant
+// defined as a label, directly or through other constants like .equ HANDLER Work.
+func labelAliases(instructions []instruction) map[string]string {
+	aliases := make(map[string]string)
+	constants := make(map[string]*constant)
+	for _, ins := range instructions {
+		switch ins := ins.(type) {
+		case *label:
+			aliases[ins.Literal] = ins.Literal
+		case *constant:
+			constants[ins.Name] = ins
+		}
+	}
+	for name, c := range constants {
+		// constants defined in terms of themselves are rejected once they are resolved
+		for steps := 0; c.IsSymbol && steps < len(constants); steps++ {
+			if l, ok := aliases[c.Literal]; ok {
+				aliases[name] = l
+				break
+			}
+			next, ok := constants[c.Literal]
+			if !ok {
+				break
+			}
+			c = next
+		}
+	}
+	return aliases
+}
+
+// countCode returns the number of instructions that are translated into machine code not counting
+// the padding of placement directives.
+func countCode(instructions []instruction) int {
+	var n int
+	for _, ins := range instructions {
+		if isCode(ins) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,455 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want string
+	}{
+		"RedundantLoad": {
+			in: `
+	@x
+	D=M
+	@x
+	M=D+1
+`,
+			want: `
+	@x
+	D=M
+	M=D+1
+`,
+		},
+		"KeepLoadAfterWritingA": {
+			in: `
+	@x
+	A=M
+	@x
+	M=0
+`,
+			want: `
+	@x
+	A=M
+	@x
+	M=0
+`,
+		},
+		"KeepLoadAfterLabel": {
+			in: `
+	@x
+	D=M
+(LOOP)
+	@x
+	M=D
+`,
+			want: `
+	@x
+	D=M
+(LOOP)
+	@x
+	M=D
+`,
+		},
+		"OverwrittenLoad": {
+			in: `
+	@R1
+	@y
+	M=0
+`,
+			want: `
+	@y
+	M=0
+`,
+		},
+		"RedundantCopy": {
+			in: `
+	D=A
+	A=D
+	D=M
+	M=D
+`,
+			want: `
+	D=A
+	D=M
+`,
+		},
+		"KeepCopyBetweenAAndM": {
+			in: `
+	A=M
+	M=A
+`,
+			want: `
+	A=M
+	M=A
+`,
+		},
+		"JumpToNext": {
+			in: `
+	@NEXT
+	D;JGT
+(NEXT)
+	@x
+	M=D
+`,
+			want: `
+(NEXT)
+	@x
+	M=D
+`,
+		},
+		"KeepJumpToNextIfAIsRead": {
+			in: `
+	@NEXT
+	0;JMP
+(NEXT)
+	D=A
+`,
+			want: `
+	@NEXT
+	0;JMP
+(NEXT)
+	D=A
+`,
+		},
+		"KeepJumpWithDest": {
+			in: `
+	@NEXT
+	D=D-1;JGT
+(NEXT)
+	@x
+`,
+			want: `
+	@NEXT
+	D=D-1;JGT
+(NEXT)
+	@x
+`,
+		},
+		"ConstantLoad": {
+			in: `
+	@0
+	D=A
+	@x
+	M=D
+	@1
+	D=A
+	@y
+	M=D
+`,
+			want: `
+	D=0
+	@x
+	M=D
+	D=1
+	@y
+	M=D
+`,
+		},
+		"KeepConstantLoadIfAIsRead": {
+			in: `
+	@0
+	D=A
+	M=D
+`,
+			want: `
+	@0
+	D=A
+	M=D
+`,
+		},
+		"RewritesEnableEachOther": {
+			in: `
+	@i
+	D=M
+	@i
+	@END
+	D;JEQ
+(END)
+	@i
+	M=D
+`,
+			want: `
+	@i
+	D=M
+(END)
+	@i
+	M=D
+`,
+		},
+		"LabelsAreResolvedAfterOptimizing": {
+			in: `
+	@R1
+	@y
+	D=A
+	A=D
+	@LOOP
+	0;JMP
+(LOOP)
+	@LOOP
+	0;JMP
+`,
+			want: `
+	@y
+	D=A
+(LOOP)
+	@LOOP
+	0;JMP
+`,
+		},
+		"SkipProgramsComputingAddressesFromLabels": {
+			in: `
+	@x
+	@LOOP+2
+	0;JMP
+(LOOP)
+`,
+			want: `
+	@x
+	@LOOP+2
+	0;JMP
+(LOOP)
+`,
+		},
+		"SkipProgramsComputingAddressesFromLabelsInConstants": {
+			in: `
+.equ HANDLER Work
+.equ NEXT HANDLER+2
+	@x
+	@NEXT
+	0;JMP
+(Work)
+`,
+			want: `
+.equ HANDLER Work
+.equ NEXT HANDLER+2
+	@x
+	@NEXT
+	0;JMP
+(Work)
+`,
+		},
+		"PinJumpsToNumbers": {
+			in: `
+	@1
+	D=A
+	@5
+	0;JMP
+	@7
+	@R0
+	M=D
+(END)
+	@END
+	0;JMP
+`,
+			want: `
+	D=1
+	@4
+	0;JMP
+	@7
+	@R0
+	M=D
+(END)
+	@END
+	0;JMP
+`,
+		},
+		"KeepJumpsToNumbersOutsideOfTheProgram": {
+			in: `
+	@R1
+	@R2
+	@100
+	0;JMP
+`,
+			want: `
+	@100
+	0;JMP
+`,
+		},
+		"KeepCodeBeforeROMAddressStoredInRAM": {
+			in: `
+	@9
+	D=A
+	@R13
+	M=D
+	@R0
+	@R13
+	A=M
+	0;JMP
+	M=1
+	@R1
+	M=1
+	@R0
+	@R2
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+			want: `
+	@9
+	D=A
+	@R13
+	M=D
+	@R0
+	@R13
+	A=M
+	0;JMP
+	M=1
+	@R1
+	M=1
+	@R2
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+		},
+		"KeepFirstReferenceToDeclaredVariable": {
+			in: `
+.var x
+.block buf 2
+	@x
+	@y
+	M=1
+	@buf+1
+	@y
+	M=1
+	@x
+	@z
+	M=1
+`,
+			want: `
+.var x
+.block buf 2
+	@x
+	@y
+	M=1
+	@buf+1
+	@y
+	M=1
+	@z
+	M=1
+`,
+		},
+		"KeepFirstReferenceToVariable": {
+			in: `
+	@x
+	@y
+	M=1
+	@x
+	@z
+	M=1
+`,
+			want: `
+	@x
+	@y
+	M=1
+	@z
+	M=1
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got bytes.Buffer
+			asm := Assembler{Optimize: true}
+			err := asm.Assemble(strings.NewReader(tc.in), &got)
+			assertNoError(t, err)
+
+			var want bytes.Buffer
+			err = Assemble(strings.NewReader(tc.want), &want)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", tc.in, got.String(), want.String())
+		})
+	}
+}
+
+func TestOptimizeReportsSavedInstructions(t *testing.T) {
+	in := `
+// hack:ignore-file single-use-symbol
+	@R1
+	@y
+	D=M
+	@y
+	M=D+1
+	@0
+	D=A
+	@z
+	M=D
+`
+	var optimizations, warnings strings.Builder
+	asm := Assembler{Optimize: true, Optimizations: &optimizations, Warnings: &warnings}
+	err := asm.Assemble(strings.NewReader(in), new(strings.Builder))
+	assertNoError(t, err)
+
+	want := `redundant-load    1
+overwritten-load  1
+constant-load     1
+saved 3 of 9 instructions
+`
+	assertDeepEquals(t, "Assemble", in, optimizations.String(), want)
+	assertDeepEquals(t, "Assemble", in, warnings.String(), "")
+}
+
+func TestOptimizeWarnsAboutCodeBeforeROMAddressStoredInRAM(t *testing.T) {
+	in := "\t@3\n\tD=A\n\t@R13\n\tM=D\n\t@R13\n\tA=M\n\t0;JMP"
+	var warnings strings.Builder
+	asm := Assembler{Optimize: true, Warnings: &warnings}
+	err := asm.Assemble(strings.NewReader(in), new(strings.Builder))
+	assertNoError(t, err)
+
+	want := "line 1: warning: code before ROM address 3 is not optimized as the program might jump to the number loaded here indirectly [not-optimized]\n"
+	assertDeepEquals(t, "Assemble", in, warnings.String(), want)
+}
+
+func TestOptimizeKeepsDeclaredVariablesUsed(t *testing.T) {
+	in := ".var x\n\t@x\n\t@R1\n\tM=1"
+	var warnings strings.Builder
+	asm := Assembler{Optimize: true, WarningsAsErrors: true, Warnings: &warnings}
+	err := asm.Assemble(strings.NewReader(in), new(strings.Builder))
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Assemble", in, warnings.String(), "")
+}
+
+func TestOptimizePreservesBehavior(t *testing.T) {
+	routines := []string{"std.mul", "std.div", "std.mod", "std.shl", "std.shr"}
+
+	for _, routine := range routines {
+		t.Run(routine, func(t *testing.T) {
+			rom := stdProgram(t, routine)
+			optimized := optimizedStdProgram(t, routine)
+
+			for _, x := range values {
+				for _, y := range values {
+					want := callStd(t, rom, x, y)
+					got := callStd(t, optimized, x, y)
+
+					if got.RAM[0] != want.RAM[0] {
+						t.Errorf("optimized %s(%d, %d) = %d; want %d", routine, x, y, int16(got.RAM[0]), int16(want.RAM[0]))
+					}
+				}
+			}
+		})
+	}
+}
+
+// optimizedStdProgram is like stdProgram but assembles the program with optimizations.
+func optimizedStdProgram(t *testing.T, routine string) []uint16 {
+	t.Helper()
+
+	var machine bytes.Buffer
+	asm := Assembler{Optimize: true}
+	err := asm.Assemble(strings.NewReader("@RET\nD=A\n@std.ret\nM=D\n@"+routine+"\n0;JMP\n(RET)\n@R0\nM=D\n(END)\n@END\n0;JMP"), &machine)
+	assertNoError(t, err)
+
+	rom, err := readProgram(&machine)
+	assertNoError(t, err)
+	return rom
+}
@@ -0,0 +1,105 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// romSize is the number of instructions that fit into the ROM of the hack computer.
+const romSize = 1 << 15
+
+// placement represents the placement directives .org address and .align n. It is a
+// pseudo-instruction that pads the program with filler instructions so that the next instruction is
+// placed at given ROM address or at the next ROM address that is a multiple of n.
+type placement struct {
+	Directive string
+	Value     uint16
+	Pos       pos
+}
+
+func (p placement) Instruction() {}
+
+func (p placement) String() string {
+	return p.Directive + " " + strconv.Itoa(int(p.Value))
+}
+
+// parsePlacement parses a placement directive like .org 0x100 or .align 16. The value is an
+// expression that must not refer to symbols as labels can only be resolved once the padding is known.
+func parsePlacement(in string) (*placement, error) {
+	directive, value := cutField(in)
+	if directive != ".org" && directive != ".align" {
+		return nil, errors.New("failed to parse placement: placement directives need to start with .org or .align")
+	}
+	if value == "" {
+		return nil, fmt.Errorf("failed to parse %s: missing value", directive)
+	}
+	e, err := parseExpr(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: invalid value %q: %v", directive, value, err)
+	}
+	if hasSymbols(e) {
+		return nil, fmt.Errorf("failed to parse %s: value %s must not refer to symbols", directive, e)
+	}
+	v, err := evalExpr(e, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", directive, err)
+	}
+	if directive == ".org" && (v < 0 || v >= romSize) {
+		return nil, fmt.Errorf("failed to parse .org: address %d must be between 0 and %d", v, romSize-1)
+	}
+	if directive == ".align" && (v < 1 || v > romSize) {
+		return nil, fmt.Errorf("failed to parse .align: alignment %d must be between 1 and %d", v, romSize)
+	}
+	return &placement{Directive: directive, Value: uint16(v)}, nil
+}
+
+// padding returns the number of filler instructions needed to place the next instruction if the
+// program counter is at pc. Code cannot be placed at an address that is already taken.
+func (p placement) padding(pc int) (int, error) {
+	var next int
+	if p.Directive == ".org" {
+		next = int(p.Value)
+		if next < pc {
+			return 0, errorf(p.Pos, "failed to place code at ROM address %d: overlaps code at ROM addresses up to %d", next, pc-1)
+		}
+	} else {
+		align := int(p.Value)
+		next = (pc + align - 1) / align * align
+	}
+	if next > romSize {
+		return 0, errorf(p.Pos, "failed to place code at ROM address %d: exceeds the ROM of %d instructions", next, romSize)
+	}
+	return next - pc, nil
+}
+
+// filler returns the instruction used to pad the program for placement directives together with its
+// machine code.
+func (a *Assembler) filler() (instruction, string, error) {
+	command := a.Filler
+	if command == "" {
+		command = "0"
+	}
+	parsed, err := a.parseCommand(command)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse filler %q: %v", command, err)
+	}
+	if len(parsed) != 1 {
+		return nil, "", fmt.Errorf("failed to parse filler %q: filler must be a single instruction", command)
+	}
+
+	switch ins := parsed[0].(type) {
+	case *aInstruction:
+		if ins.IsSymbol || ins.Expr != nil {
+			return nil, "", fmt.Errorf("failed to parse filler %q: filler must not refer to symbols", command)
+		}
+		return ins, fmt.Sprintf("%016b", codeAInstruction(ins)), nil
+	case *cInstruction:
+		code, err := codeCInstruction(ins)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse filler %q: %v", command, err)
+		}
+		return ins, string(code), nil
+	}
+	return nil, "", fmt.Errorf("failed to parse filler %q: filler must be an A- or C-instruction", command)
+}
@@ -0,0 +1,162 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParsePlacement(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want instruction
+	}{
+		"Org": {
+			in:   `.org 0x100`,
+			want: &placement{Directive: ".org", Value: 256},
+		},
+		"Align": {
+			in:   `.align 2*8`,
+			want: &placement{Directive: ".align", Value: 16},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parsePlacement(tc.in)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "parsePlacement", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingValue": {
+			in: `.org`,
+		},
+		"RejectSymbol": {
+			in: `.org HANDLERS`,
+		},
+		"RejectAddressExceedingROM": {
+			in: `.org 0x8000`,
+		},
+		"RejectZeroAlignment": {
+			in: `.align 0`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parsePlacement(tc.in)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssemblePlacement(t *testing.T) {
+	tests := map[string]struct {
+		in     string
+		filler string
+		want   string
+	}{
+		"Org": {
+			in: `
+	@HANDLER
+.org 3
+(HANDLER)
+	D=M
+`,
+			want: `0000000000000011
+1110101010000000
+1110101010000000
+1111110000010000
+`,
+		},
+		"Align": {
+			in: `
+	D=M
+	D=M
+	D=M
+.align 2
+(ALIGNED)
+	@ALIGNED
+.align 2
+	D=M
+`,
+			want: `1111110000010000
+1111110000010000
+1111110000010000
+1110101010000000
+0000000000000100
+1110101010000000
+1111110000010000
+`,
+		},
+		"AlignedAlready": {
+			in: `
+	D=M
+	D=M
+.align 2
+	D=M
+`,
+			want: `1111110000010000
+1111110000010000
+1111110000010000
+`,
+		},
+		"Filler": {
+			in: `
+.org 2
+	D=M
+`,
+			filler: "@0",
+			want: `0000000000000000
+0000000000000000
+1111110000010000
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got bytes.Buffer
+			asm := Assembler{Filler: tc.filler}
+			err := asm.Assemble(strings.NewReader(tc.in), &got)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", tc.in, got.String(), tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in     string
+		filler string
+	}{
+		"RejectOverlap": {
+			in: `
+.org 2
+	D=M
+	D=M
+.org 3
+`,
+		},
+		"RejectFillerReferringToSymbol": {
+			in:     `.org 2`,
+			filler: "@LOOP",
+		},
+		"RejectFillerOfMultipleInstructions": {
+			in:     `.org 2`,
+			filler: "PUSH D",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			asm := Assembler{Filler: tc.filler}
+			err := asm.Assemble(strings.NewReader(tc.in), new(strings.Builder))
+			assertError(t, err)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package hack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pseudoInstructions maps the mnemonic of a pseudo-instruction to the function expanding it into
+// A- and C-instructions. Pseudo-instructions are expanded while parsing so labels are resolved
+// using the ROM addresses of the expanded instructions. The stack pointer is the symbol holding the
+// address of the top of the stack.
+var pseudoInstructions = map[string]func(stackPointer string, operands []string) ([]instruction, error){
+	// GOTO label jumps to label.
+	"GOTO": func(_ string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "label"); err != nil {
+			return nil, err
+		}
+		return withLoad(operands[0], &cInstruction{Comp: "0", Jump: "JMP"})
+	},
+	// IFGT label jumps to label if D > 0. The other conditional jumps follow the jump mnemonics.
+	"IFGT": conditionalJump("JGT"),
+	"IFEQ": conditionalJump("JEQ"),
+	"IFGE": conditionalJump("JGE"),
+	"IFLT": conditionalJump("JLT"),
+	"IFNE": conditionalJump("JNE"),
+	"IFLE": conditionalJump("JLE"),
+	// LOAD register, address loads the value at given address into the D or A register.
+	"LOAD": func(_ string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "register", "address"); err != nil {
+			return nil, err
+		}
+		if operands[0] != "D" && operands[0] != "A" {
+			return nil, fmt.Errorf("cannot load into %q: expected register D or A", operands[0])
+		}
+		return withLoad(operands[1], &cInstruction{Dest: operands[0], Comp: "M"})
+	},
+	// STORE address, value stores D, 0, 1 or -1 at given address.
+	"STORE": func(_ string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "address", "value"); err != nil {
+			return nil, err
+		}
+		if !isStorable(operands[1]) {
+			return nil, fmt.Errorf("cannot store %q: expected D, 0, 1 or -1", operands[1])
+		}
+		return withLoad(operands[0], &cInstruction{Dest: "M", Comp: operands[1]})
+	},
+	// INC address increments the value at given address.
+	"INC": func(_ string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "address"); err != nil {
+			return nil, err
+		}
+		return withLoad(operands[0], &cInstruction{Dest: "M", Comp: "M+1"})
+	},
+	// DEC address decrements the value at given address.
+	"DEC": func(_ string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "address"); err != nil {
+			return nil, err
+		}
+		return withLoad(operands[0], &cInstruction{Dest: "M", Comp: "M-1"})
+	},
+	// PUSH value pushes D, 0, 1 or -1 onto the stack. The stack pointer points to the address
+	// following the top of the stack.
+	"PUSH": func(stackPointer string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "value"); err != nil {
+			return nil, err
+		}
+		if !isStorable(operands[0]) {
+			return nil, fmt.Errorf("cannot push %q: expected D, 0, 1 or -1", operands[0])
+		}
+		return withLoad(stackPointer,
+			&cInstruction{Dest: "AM", Comp: "M+1"},
+			&cInstruction{Dest: "A", Comp: "A-1"},
+			&cInstruction{Dest: "M", Comp: operands[0]},
+		)
+	},
+	// POP register pops the top of the stack into the D or A register.
+	"POP": func(stackPointer string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "register"); err != nil {
+			return nil, err
+		}
+		if operands[0] != "D" && operands[0] != "A" {
+			return nil, fmt.Errorf("cannot pop into %q: expected register D or A", operands[0])
+		}
+		return withLoad(stackPointer,
+			&cInstruction{Dest: "AM", Comp: "M-1"},
+			&cInstruction{Dest: operands[0], Comp: "M"},
+		)
+	},
+}
+
+// conditionalJump returns a pseudo-instruction like IFGT label which jumps to label if D
+// satisfies the condition of given jump mnemonic.
+func conditionalJump(jump string) func(string, []string) ([]instruction, error) {
+	return func(_ string, operands []string) ([]instruction, error) {
+		if err := expectOperands(operands, "label"); err != nil {
+			return nil, err
+		}
+		return withLoad(operands[0], &cInstruction{Comp: "D", Jump: jump})
+	}
+}
+
+// withLoad returns the A-instruction loading operand into A followed by given instructions.
+func withLoad(operand string, instructions ...instruction) ([]instruction, error) {
+	ins, err := parseAInstruction("@" + operand)
+	if err != nil {
+		return nil, err
+	}
+	return append(expandAInstruction(ins), instructions...), nil
+}
+
+// isStorable returns true if value can be written to memory by a single C-instruction without
+// changing the A register.
+func isStorable(value string) bool {
+	return value == "D" || value == "0" || value == "1" || value == "-1"
+}
+
+// expectOperands returns an error if the number of operands does not match the given operand
+// names.
+func expectOperands(operands []string, names ...string) error {
+	if len(operands) == len(names) {
+		return nil
+	}
+	return fmt.Errorf("expected operand(s) %s, got %d operand(s)", strings.Join(names, ", "), len(operands))
+}
+
+// splitOperands splits the operands of a pseudo-instruction which are separated by commas or
+// whitespace.
+func splitOperands(operands string) []string {
+	return strings.FieldsFunc(operands, isArgSeparator)
+}
+
+// stackPointer returns the symbol holding the address of the top of the stack used by the PUSH and
+// POP pseudo-instructions.
+func (a *Assembler) stackPointer() string {
+	if a.StackPointer == "" {
+		return "SP"
+	}
+	return a.StackPointer
+}
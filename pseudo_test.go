@@ -0,0 +1,139 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParsePseudoInstructions(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want []instruction
+	}{
+		"GOTO": {
+			in: "GOTO END",
+			want: []instruction{
+				&aInstruction{Literal: "END", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Comp: "0", Jump: "JMP", Pos: pos{Line: 1}},
+			},
+		},
+		"IFGT": {
+			in: "IFGT END",
+			want: []instruction{
+				&aInstruction{Literal: "END", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Comp: "D", Jump: "JGT", Pos: pos{Line: 1}},
+			},
+		},
+		"GOTOLocalLabel": {
+			in: "(MAIN)\n\tGOTO .end",
+			want: []instruction{
+				&label{Literal: "MAIN", Pos: pos{Line: 1}},
+				&aInstruction{Literal: "MAIN.end", IsSymbol: true, Pos: pos{Line: 2}},
+				&cInstruction{Comp: "0", Jump: "JMP", Pos: pos{Line: 2}},
+			},
+		},
+		"LOAD": {
+			in: "LOAD D, x",
+			want: []instruction{
+				&aInstruction{Literal: "x", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Dest: "D", Comp: "M", Pos: pos{Line: 1}},
+			},
+		},
+		"STORE": {
+			in: "STORE x, D",
+			want: []instruction{
+				&aInstruction{Literal: "x", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Dest: "M", Comp: "D", Pos: pos{Line: 1}},
+			},
+		},
+		"INC": {
+			in: "INC x",
+			want: []instruction{
+				&aInstruction{Literal: "x", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Dest: "M", Comp: "M+1", Pos: pos{Line: 1}},
+			},
+		},
+		"DEC": {
+			in: "DEC x",
+			want: []instruction{
+				&aInstruction{Literal: "x", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Dest: "M", Comp: "M-1", Pos: pos{Line: 1}},
+			},
+		},
+		"PUSH": {
+			in: "PUSH D",
+			want: []instruction{
+				&aInstruction{Literal: "SP", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Dest: "AM", Comp: "M+1", Pos: pos{Line: 1}},
+				&cInstruction{Dest: "A", Comp: "A-1", Pos: pos{Line: 1}},
+				&cInstruction{Dest: "M", Comp: "D", Pos: pos{Line: 1}},
+			},
+		},
+		"POP": {
+			in: "POP D",
+			want: []instruction{
+				&aInstruction{Literal: "SP", IsSymbol: true, Pos: pos{Line: 1}},
+				&cInstruction{Dest: "AM", Comp: "M-1", Pos: pos{Line: 1}},
+				&cInstruction{Dest: "D", Comp: "M", Pos: pos{Line: 1}},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := new(Assembler).parse(strings.NewReader(tc.in), "")
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "parse", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectGOTOWithoutLabel": {
+			in: "GOTO",
+		},
+		"RejectLOADIntoM": {
+			in: "LOAD M, x",
+		},
+		"RejectSTOREOfA": {
+			in: "STORE x, A",
+		},
+		"RejectPUSHOfTooManyOperands": {
+			in: "PUSH D, A",
+		},
+		"RejectPOPIntoM": {
+			in: "POP M",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := new(Assembler).parse(strings.NewReader(tc.in), "")
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssemblePseudoInstructionsWithCustomStackPointer(t *testing.T) {
+	in := `
+	PUSH D
+(END)
+	GOTO END
+`
+	want := `0000000000001101
+1111110111101000
+1110110010100000
+1110001100001000
+0000000000000100
+1110101010000111
+`
+	var got bytes.Buffer
+	asm := Assembler{StackPointer: "R13"}
+	err := asm.Assemble(strings.NewReader(in), &got)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Assemble", in, got.String(), want)
+}
@@ -0,0 +1,291 @@
+package hack
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Rule is a user-defined peephole rewrite read using ReadRules. It replaces a sequence of A- and
+// C-instructions matching its pattern by its replacement. Rules are applied as written, it is up to
+// the author of a rule to ensure that the replacement behaves like the code it replaces.
+type Rule struct {
+	Name        string
+	pos         pos
+	pattern     []pattern
+	replacement []string
+}
+
+// pattern matches a single instruction. Wildcards are the names of the groups of the regular
+// expression in order.
+type pattern struct {
+	re        *regexp.Regexp
+	wildcards []string
+}
+
+// ReadRules reads rewrite rules from r. Rules are attributed to the file name in errors. Every rule
+// is written as
+//
+//	rule redundant-store
+//		@$x
+//		M=%r
+//		@$x
+//		M=%r
+//	=>
+//		@$x
+//		M=%r
+//	end
+//
+// A pattern matches consecutive instructions. $name matches any symbol or number and %name matches
+// the register A, D or M. A wildcard that occurs more than once needs to match the same text every
+// time. The replacement can use the wildcards of the pattern as well as pseudo-instructions.
+func ReadRules(r io.Reader, name string) ([]*Rule, error) {
+	lines, err := readLines(r, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+	names := make(map[string]pos)
+	var rule *Rule
+	var replacing bool
+	var bound map[string]bool
+	for _, l := range lines {
+		command := l.Command()
+		if command == "" {
+			continue
+		}
+
+		keyword, rest := cutField(command)
+		switch {
+		case rule == nil && keyword == "rule":
+			if rest == "" || strings.ContainsFunc(rest, unicode.IsSpace) {
+				return nil, errorf(l.Pos, "failed to parse rule %q: expected a name without whitespace", command)
+			}
+			if previous, ok := names[rest]; ok {
+				return nil, errorf(l.Pos, "failed to parse rule %q: rule re-declared, previous declaration at %s", rest, previous)
+			}
+			names[rest] = l.Pos
+			rule, replacing, bound = &Rule{Name: rest, pos: l.Pos}, false, make(map[string]bool)
+		case rule == nil:
+			return nil, errorf(l.Pos, "failed to parse rules: expected \"rule NAME\" instead got %q", command)
+		case command == "=>" && !replacing:
+			if len(rule.pattern) == 0 {
+				return nil, errorf(l.Pos, "failed to parse rule %q: pattern is empty", rule.Name)
+			}
+			replacing = true
+		case command == "end" && replacing:
+			rules = append(rules, rule)
+			rule = nil
+		case replacing:
+			if err := checkReplacement(command, bound); err != nil {
+				return nil, errorf(l.Pos, "failed to parse rule %q: %v", rule.Name, err)
+			}
+			rule.replacement = append(rule.replacement, command)
+		default:
+			pt, err := compilePattern(command, bound)
+			if err != nil {
+				return nil, errorf(l.Pos, "failed to parse rule %q: %v", rule.Name, err)
+			}
+			rule.pattern = append(rule.pattern, pt)
+		}
+	}
+	if rule != nil {
+		return nil, errorf(rule.pos, "failed to parse rule %q: missing end", rule.Name)
+	}
+	return rules, nil
+}
+
+// wildcard matches a wildcard like $x or %r that is not part of a symbol like LOOP$MAC.1.
+var wildcard = regexp.MustCompile(`[$%][A-Za-z_][A-Za-z0-9_]*`)
+
+// wildcards returns the start and end index of every wildcard in s.
+func wildcards(s string) [][]int {
+	var found [][]int
+	for _, loc := range wildcard.FindAllStringIndex(s, -1) {
+		if loc[0] > 0 && s[loc[0]] == '$' && validSymbolChars(rune(s[loc[0]-1])) {
+			continue
+		}
+		found = append(found, loc)
+	}
+	return found
+}
+
+// compilePattern compiles an instruction of a pattern into a regular expression matching the
+// instruction as it is printed. Wildcards are turned into groups and recorded as bound.
+func compilePattern(command string, bound map[string]bool) (pattern, error) {
+	if command[0] == '(' || command[0] == '.' {
+		return pattern{}, fmt.Errorf("pattern %q can only match A- and C-instructions", command)
+	}
+
+	var pt pattern
+	var b strings.Builder
+	b.WriteString("^")
+	var start int
+	for _, loc := range wildcards(command) {
+		b.WriteString(regexp.QuoteMeta(command[start:loc[0]]))
+		name := command[loc[0]:loc[1]]
+		if name[0] == '$' {
+			b.WriteString(`([\p{L}\p{N}_.$:]+)`)
+		} else {
+			b.WriteString(`([ADM])`)
+		}
+		bound[name] = true
+		pt.wildcards = append(pt.wildcards, name)
+		start = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(command[start:]))
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return pattern{}, err
+	}
+	pt.re = re
+	return pt, nil
+}
+
+// checkReplacement checks that an instruction of a replacement only uses bound wildcards and
+// results in A- and C-instructions.
+func checkReplacement(command string, bound map[string]bool) error {
+	example := make(map[string]string)
+	for _, loc := range wildcards(command) {
+		name := command[loc[0]:loc[1]]
+		if !bound[name] {
+			return fmt.Errorf("replacement %q uses wildcard %s that is not part of the pattern", command, name)
+		}
+		example[name] = "x"
+		if name[0] == '%' {
+			example[name] = "D"
+		}
+	}
+	instructions, err := new(Assembler).parseCommand(bindWildcards(command, example))
+	if err != nil {
+		return fmt.Errorf("invalid replacement %q: %v", command, err)
+	}
+	for _, ins := range instructions {
+		if !isCode(ins) {
+			return fmt.Errorf("replacement %q can only consist of A- and C-instructions", command)
+		}
+		if c, ok := ins.(*cInstruction); ok {
+			if _, err := codeCInstruction(c); err != nil {
+				return fmt.Errorf("invalid replacement %q: %v", command, err)
+			}
+		}
+	}
+	return nil
+}
+
+// bindWildcards replaces the wildcards in command by the text they are bound to.
+func bindWildcards(command string, bindings map[string]string) string {
+	var b strings.Builder
+	var start int
+	for _, loc := range wildcards(command) {
+		b.WriteString(command[start:loc[0]])
+		b.WriteString(bindings[command[loc[0]:loc[1]]])
+		start = loc[1]
+	}
+	b.WriteString(command[start:])
+	return b.String()
+}
+
+// match returns the bindings of the wildcards if the rule matches the instructions starting at
+// index i.
+func (r *Rule) match(instructions []instruction, i int) (map[string]string, bool) {
+	if i+len(r.pattern) > len(instructions) {
+		return nil, false
+	}
+	bindings := make(map[string]string)
+	for j, pt := range r.pattern {
+		ins := instructions[i+j]
+		if !isCode(ins) {
+			return nil, false
+		}
+		groups := pt.re.FindStringSubmatch(fmt.Sprint(ins))
+		if groups == nil {
+			return nil, false
+		}
+		for k, name := range pt.wildcards {
+			if bound, ok := bindings[name]; ok && bound != groups[k+1] {
+				return nil, false
+			}
+			bindings[name] = groups[k+1]
+		}
+	}
+	return bindings, true
+}
+
+// applyRules replaces every sequence of instructions matching one of a.Rules by the replacement of
+// the rule. Rules are tried in the order they are given. Instructions that were produced by a
+// replacement are not matched again. Every match is written to a.RuleMatches if it is not nil. The
+// instructions are left as they are if a.RulesDryRun is set.
+func (a *Assembler) applyRules(instructions []instruction) ([]instruction, error) {
+	if len(a.Rules) == 0 {
+		return instructions, nil
+	}
+
+	var result []instruction
+	for i := 0; i < len(instructions); {
+		r, bindings := a.matchRule(instructions, i)
+		if r == nil {
+			result = append(result, instructions[i])
+			i++
+			continue
+		}
+
+		matched := instructions[i : i+len(r.pattern)]
+		p := codePos(matched[0])
+		var replacement []instruction
+		for _, command := range r.replacement {
+			parsed, err := a.parseCommand(bindWildcards(command, bindings))
+			if err != nil {
+				return nil, errorf(p, "failed to apply rule %q: %v", r.Name, err)
+			}
+			for _, ins := range parsed {
+				setCodePos(ins, p)
+			}
+			replacement = append(replacement, parsed...)
+		}
+		if a.RuleMatches != nil {
+			fmt.Fprintf(a.RuleMatches, "%s: %s: %s => %s\n", p, r.Name, joinInstructions(matched), joinInstructions(replacement))
+		}
+
+		if a.RulesDryRun {
+			result = append(result, matched...)
+		} else {
+			result = append(result, replacement...)
+		}
+		i += len(matched)
+	}
+	return result, nil
+}
+
+// matchRule returns the first rule matching the instructions starting at index i.
+func (a *Assembler) matchRule(instructions []instruction, i int) (*Rule, map[string]string) {
+	for _, r := range a.Rules {
+		if bindings, ok := r.match(instructions, i); ok {
+			return r, bindings
+		}
+	}
+	return nil, nil
+}
+
+// setCodePos sets the position of an instruction that is translated into machine code.
+func setCodePos(ins instruction, p pos) {
+	switch ins := ins.(type) {
+	case *aInstruction:
+		ins.Pos = p
+	case *cInstruction:
+		ins.Pos = p
+	}
+}
+
+// joinInstructions joins the instructions separated by commas.
+func joinInstructions(instructions []instruction) string {
+	s := make([]string, len(instructions))
+	for i, ins := range instructions {
+		s[i] = fmt.Sprint(ins)
+	}
+	return strings.Join(s, ", ")
+}
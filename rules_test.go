@@ -0,0 +1,218 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestApplyRules(t *testing.T) {
+	rules := `
+// pushing a constant is done in one instruction less by incrementing SP first
+rule push-constant
+	@$c
+	D=A
+	@SP
+	A=M
+	M=D
+	@SP
+	M=M+1
+=>
+	@$c
+	D=A
+	PUSH D
+end
+
+rule redundant-store
+	@$x
+	M=%r
+	@$x
+	M=%r
+=>
+	@$x
+	M=%r
+end
+`
+	tests := map[string]struct {
+		in   string
+		want string
+	}{
+		"SymbolWildcard": {
+			in: `
+	@7
+	D=A
+	@SP
+	A=M
+	M=D
+	@SP
+	M=M+1
+`,
+			want: `
+	@7
+	D=A
+	@SP
+	AM=M+1
+	A=A-1
+	M=D
+`,
+		},
+		"RegisterWildcard": {
+			in: `
+	@x
+	M=D
+	@x
+	M=D
+	@y
+	M=A
+	@y
+	M=A
+`,
+			want: `
+	@x
+	M=D
+	@y
+	M=A
+`,
+		},
+		"RepeatedWildcardsNeedToMatchTheSameText": {
+			in: `
+	@x
+	M=D
+	@y
+	M=D
+	@x
+	M=D
+	@x
+	M=A
+`,
+			want: `
+	@x
+	M=D
+	@y
+	M=D
+	@x
+	M=D
+	@x
+	M=A
+`,
+		},
+		"DoNotMatchAcrossLabels": {
+			in: `
+	@x
+	M=D
+(LOOP)
+	@x
+	M=D
+`,
+			want: `
+	@x
+	M=D
+(LOOP)
+	@x
+	M=D
+`,
+		},
+		"DoNotMatchPartOfSymbols": {
+			in: `
+	@LOOP$MAC.1
+	M=D
+	@LOOP$MAC.1
+	M=D
+`,
+			want: `
+	@LOOP$MAC.1
+	M=D
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := ReadRules(strings.NewReader(rules), "vm.rules")
+			assertNoError(t, err)
+
+			var got bytes.Buffer
+			asm := Assembler{Rules: r}
+			err = asm.Assemble(strings.NewReader(tc.in), &got)
+			assertNoError(t, err)
+
+			var want bytes.Buffer
+			err = Assemble(strings.NewReader(tc.want), &want)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", tc.in, got.String(), want.String())
+		})
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		r, err := ReadRules(strings.NewReader(rules), "vm.rules")
+		assertNoError(t, err)
+		in := `
+	@x
+	M=D
+	@x
+	M=D
+`
+		var got, want bytes.Buffer
+		var matches strings.Builder
+		asm := Assembler{Rules: r, RulesDryRun: true, RuleMatches: &matches}
+		err = asm.Assemble(strings.NewReader(in), &got)
+		assertNoError(t, err)
+
+		err = Assemble(strings.NewReader(in), &want)
+		assertNoError(t, err)
+		assertDeepEquals(t, "Assemble", in, got.String(), want.String())
+
+		wantMatches := "line 2: redundant-store: @x, M=D, @x, M=D => @x, M=D\n"
+		assertDeepEquals(t, "Assemble", in, matches.String(), wantMatches)
+	})
+}
+
+func TestReadRulesErrors(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want string
+	}{
+		"RejectInstructionOutsideOfRule": {
+			in:   "@x",
+			want: `vm.rules:1: failed to parse rules: expected "rule NAME" instead got "@x"`,
+		},
+		"RejectMissingName": {
+			in:   "rule",
+			want: `vm.rules:1: failed to parse rule "rule": expected a name without whitespace`,
+		},
+		"RejectDuplicateName": {
+			in:   "rule a\n@x\n=>\nend\nrule a\n@x\n=>\nend",
+			want: `vm.rules:5: failed to parse rule "a": rule re-declared, previous declaration at vm.rules:1`,
+		},
+		"RejectEmptyPattern": {
+			in:   "rule a\n=>\nend",
+			want: `vm.rules:2: failed to parse rule "a": pattern is empty`,
+		},
+		"RejectLabelInPattern": {
+			in:   "rule a\n($l)\n=>\nend",
+			want: `vm.rules:2: failed to parse rule "a": pattern "($l)" can only match A- and C-instructions`,
+		},
+		"RejectUnboundWildcard": {
+			in:   "rule a\n@$x\n=>\n@$y\nend",
+			want: `vm.rules:4: failed to parse rule "a": replacement "@$y" uses wildcard $y that is not part of the pattern`,
+		},
+		"RejectInvalidReplacement": {
+			in:   "rule a\n@$x\n=>\nD=X\nend",
+			want: `vm.rules:4: failed to parse rule "a": invalid replacement "D=X": failed to encode a-bit from comp field "X"`,
+		},
+		"RejectMissingEnd": {
+			in:   "rule a\n@x\n=>",
+			want: `vm.rules:1: failed to parse rule "a": missing end`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadRules(strings.NewReader(tc.in), "vm.rules")
+			assertError(t, err)
+
+			assertDeepEquals(t, "ReadRules", tc.in, err.Error(), tc.want)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package hack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pos is a position in hack assembly source. Lines that were produced by expanding a macro carry
+// the name of the macro and the position of its invocation so that errors can point at both the
+// call site and the line in the macro body. Lines repeated by a .rept block carry .rept as the name
+// of the macro and the position of the .rept.
+type pos struct {
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	Macro string `json:"macro,omitempty"`
+	Call  *pos   `json:"call,omitempty"`
+}
+
+func (p pos) String() string {
+	var s string
+	if p.File == "" {
+		s = "line " + strconv.Itoa(p.Line)
+	} else if p.Line == 0 {
+		s = p.File
+	} else {
+		s = p.File + ":" + strconv.Itoa(p.Line)
+	}
+	if p.Call != nil && p.Macro == ".rept" {
+		s += fmt.Sprintf(" (in .rept at %s)", p.Call)
+	} else if p.Call != nil {
+		s += fmt.Sprintf(" (in macro %s called at %s)", p.Macro, p.Call)
+	}
+	return s
+}
+
+// errorf formats an error prefixed with the source position p so that errors are reported in a
+// consistent format.
+func errorf(p pos, format string, a ...any) error {
+	return fmt.Errorf("%s: %w", p, fmt.Errorf(format, a...))
+}
+
+// warnf writes a warning of given rule at position p to the assemblers Warnings writer if it is not
+// nil. Warnings that are suppressed by a // hack:ignore comment are dropped while the others are
+// counted.
+func (a *Assembler) warnf(p pos, rule string, format string, args ...any) {
+	if suppressed(a.suppressions, rule, p) {
+		return
+	}
+	a.warnings++
+	if a.Warnings == nil {
+		return
+	}
+	fmt.Fprintf(a.Warnings, "%s: warning: %s [%s]\n", p, fmt.Sprintf(format, args...), rule)
+}
+
+// line is a line of hack assembly source together with its position.
+type line struct {
+	Text string
+	Pos  pos
+}
+
+// Command returns the text of the line without any comment and surrounding whitespace.
+func (l line) Command() string {
+	command, _, _ := strings.Cut(l.Text, "//")
+	return strings.TrimSpace(command)
+}
+
+// readLines reads all lines from r. The lines are attributed to given file which may be empty if r
+// is not backed by a file.
+func readLines(r io.Reader, file string) ([]line, error) {
+	var lines []line
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		lines = append(lines, line{Text: s.Text(), Pos: pos{File: file, Line: n}})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read assembly: %v", err)
+	}
+	return lines, nil
+}
+
+// replaceSymbols replaces every symbol in the command part of s for which f returns a
+// replacement. A symbol is a maximal sequence of runes satisfying validSymbolChars. Comments are left
+// untouched.
+func replaceSymbols(s string, f func(symbol string) (string, bool)) string {
+	command, comment, hasComment := strings.Cut(s, "//")
+
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		symbol := command[start:end]
+		if replacement, ok := f(symbol); ok {
+			symbol = replacement
+		}
+		b.WriteString(symbol)
+		start = -1
+	}
+	for i, r := range command {
+		if validSymbolChars(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+		b.WriteRune(r)
+	}
+	flush(len(command))
+
+	if hasComment {
+		b.WriteString("//")
+		b.WriteString(comment)
+	}
+	return b.String()
+}
+
+// isSymbol returns true if s is a valid user-deﬁned symbol.
+func isSymbol(s string) bool {
+	return len(s) > 0 && (s[0] < '0' || s[0] > '9') && containsOnly(s, validSymbolChars)
+}
+
+// cutField splits s into its first field and the remainder. Fields are separated by whitespace or a
+// comma.
+func cutField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, isArgSeparator)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(strings.TrimLeft(s[i:], ", \t"))
+}
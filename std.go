@@ -0,0 +1,136 @@
+package hack
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// StdVersion is the version of the standard library shipped with the assembler. It changes
+// whenever a routine or its calling convention changes.
+const StdVersion = "1.0.0"
+
+// stdPrefix is the prefix of all symbols declared by the standard library.
+const stdPrefix = "std."
+
+// std holds the standard library. Every file declares one or more routines, see std/abi.asm for
+// the calling convention.
+//
+//go:embed std/*.asm
+var std embed.FS
+
+// includeStd appends the files of the standard library declaring the std. symbols referenced by
+// instructions but not declared by them. Files are included once and can refer to symbols declared
+// in other files.
+func includeStd(instructions []instruction) ([]instruction, error) {
+	declared := declaredSymbols(instructions)
+	refs := stdReferences(instructions, declared)
+	if len(refs) == 0 {
+		return instructions, nil
+	}
+
+	index, err := stdIndex()
+	if err != nil {
+		return nil, err
+	}
+	included := make(map[string]bool)
+	for len(refs) > 0 {
+		ref := refs[0]
+		refs = refs[1:]
+		if declared[ref.Name] {
+			continue
+		}
+		file, ok := index[ref.Name]
+		if !ok {
+			return nil, errorf(ref.Pos, "failed to include standard library: %q is not declared by the standard library version %s", ref.Name, StdVersion)
+		}
+		if included[file] {
+			continue
+		}
+		included[file] = true
+
+		lib, err := readStd(file)
+		if err != nil {
+			return nil, err
+		}
+		for name := range declaredSymbols(lib) {
+			declared[name] = true
+		}
+		refs = append(refs, stdReferences(lib, declared)...)
+		instructions = append(instructions, lib...)
+	}
+	return instructions, nil
+}
+
+// stdReference is a reference to a symbol of the standard library.
+type stdReference struct {
+	Name string
+	Pos  pos
+}
+
+// stdReferences returns the references to std. symbols in A-instructions that are not declared.
+func stdReferences(instructions []instruction, declared map[string]bool) []stdReference {
+	var refs []stdReference
+	for _, ins := range instructions {
+		a, ok := ins.(*aInstruction)
+		if !ok || (!a.IsSymbol && a.Expr == nil) {
+			continue
+		}
+		mapSymbols(operandExpr(a), func(symbol string) string {
+			if strings.HasPrefix(symbol, stdPrefix) && !declared[symbol] {
+				refs = append(refs, stdReference{Name: symbol, Pos: a.Pos})
+			}
+			return symbol
+		})
+	}
+	return refs
+}
+
+// declaredSymbols returns the labels, constants, variables and blocks declared by instructions.
+func declaredSymbols(instructions []instruction) map[string]bool {
+	declared := make(map[string]bool)
+	for _, ins := range instructions {
+		switch ins := ins.(type) {
+		case *label:
+			declared[ins.Literal] = true
+		case *constant:
+			declared[ins.Name] = true
+		case *variable:
+			declared[ins.Name] = true
+		case *array:
+			declared[ins.Name] = true
+		}
+	}
+	return declared
+}
+
+// stdIndex returns the file of the standard library declaring each std. symbol.
+func stdIndex() (map[string]string, error) {
+	files, err := fs.Glob(std, "std/*.asm")
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]string)
+	for _, file := range files {
+		instructions, err := readStd(file)
+		if err != nil {
+			return nil, err
+		}
+		for name := range declaredSymbols(instructions) {
+			index[name] = file
+		}
+	}
+	return index, nil
+}
+
+// readStd parses file of the standard library.
+func readStd(file string) ([]instruction, error) {
+	f, err := std.Open(path.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return new(Assembler).parse(f, file)
+}
@@ -0,0 +1,10 @@
+// Calling convention of the standard library.
+//
+// Arguments are passed in std.a0 to std.a3 and the return address in std.ret. Routines jump back
+// to the return address with their result in D. Routines may change A, D, M and their arguments.
+// They are not reentrant so a routine must not be called again before it returned.
+.equ std.a0 R5
+.equ std.a1 R6
+.equ std.a2 R7
+.equ std.a3 R8
+.equ std.ret R15
@@ -0,0 +1,130 @@
+// std.div returns the quotient std.a0 / std.a1 truncated towards zero. std.mod returns the
+// remainder which has the sign of std.a0. Both treat their arguments as signed values and return 0
+// if std.a1 is 0.
+.var std.divmod.mod
+.var std.divmod.q
+.var std.divmod.r
+.var std.divmod.qneg
+.var std.divmod.rneg
+.var std.divmod.i
+
+(std.div)
+	@std.divmod.mod
+	M=0
+	@std.divmod
+	0;JMP
+(std.mod)
+	@std.divmod.mod
+	M=-1
+(std.divmod)
+	@std.divmod.qneg
+	M=0
+	@std.divmod.rneg
+	M=0
+	@std.a0
+	D=M
+	@.dividend
+	D;JGE
+	@std.divmod.qneg
+	M=-1
+	@std.divmod.rneg
+	M=-1
+	@std.a0
+	M=-M
+(.dividend)
+	@std.a1
+	D=M
+	@.zero
+	D;JEQ
+	@.divisor
+	D;JGT
+	@std.divmod.qneg
+	M=!M
+	@std.a1
+	M=-M
+(.divisor) // unsigned long division of the magnitudes, -32768 has the magnitude 32768
+	@std.divmod.q
+	M=0
+	@std.divmod.r
+	M=0
+	@16
+	D=A
+	@std.divmod.i
+	M=D
+(.loop) // shift the next bit of the dividend into the remainder
+	@std.divmod.r
+	D=M
+	M=D+M
+	@std.a0
+	D=M
+	@.shifted
+	D;JGE
+	@std.divmod.r
+	M=M+1
+(.shifted)
+	@std.a0
+	D=M
+	M=D+M
+	@std.divmod.q
+	D=M
+	M=D+M
+	@std.divmod.r // the remainder is at least the divisor if bit 15 is set as the divisor is at most 32768
+	D=M
+	@.subtract
+	D;JLT
+	@std.a1
+	D=M
+	@.next
+	D;JLT
+	@std.divmod.r
+	D=M
+	@std.a1
+	D=D-M
+	@.next
+	D;JLT
+(.subtract)
+	@std.a1
+	D=M
+	@std.divmod.r
+	M=M-D
+	@std.divmod.q
+	M=M+1
+(.next)
+	@std.divmod.i
+	MD=M-1
+	@.loop
+	D;JGT
+
+	@std.divmod.qneg
+	D=M
+	@.quotient
+	D;JEQ
+	@std.divmod.q
+	M=-M
+(.quotient)
+	@std.divmod.rneg
+	D=M
+	@.remainder
+	D;JEQ
+	@std.divmod.r
+	M=-M
+(.remainder)
+	@std.divmod.mod
+	D=M
+	@.remainderResult
+	D;JNE
+	@std.divmod.q
+	D=M
+	@.return
+	0;JMP
+(.remainderResult)
+	@std.divmod.r
+	D=M
+	@.return
+	0;JMP
+(.zero)
+	D=0
+(.return)
+	@std.ret
+	A=M
+	0;JMP
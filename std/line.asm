@@ -0,0 +1,145 @@
+// std.line draws a black line from column std.a0 and row std.a1 to column std.a2 and row std.a3
+// using std.pixel.
+.var std.line.ret
+.var std.line.x
+.var std.line.y
+.var std.line.x1
+.var std.line.y1
+.var std.line.dx
+.var std.line.dy
+.var std.line.sx
+.var std.line.sy
+.var std.line.err
+.var std.line.e2
+
+(std.line)
+	@std.ret
+	D=M
+	@std.line.ret
+	M=D
+	@std.a0
+	D=M
+	@std.line.x
+	M=D
+	@std.a1
+	D=M
+	@std.line.y
+	M=D
+	@std.a2
+	D=M
+	@std.line.x1
+	M=D
+	@std.a3
+	D=M
+	@std.line.y1
+	M=D
+
+	// Bresenham's algorithm with dx = |x1-x|, dy = -|y1-y| and the steps sx and sy towards the end
+	@std.line.sx
+	M=1
+	@std.line.x
+	D=M
+	@std.line.x1
+	D=M-D
+	@std.line.dx
+	M=D
+	@.absdx
+	D;JGE
+	@std.line.dx
+	M=-M
+	@std.line.sx
+	M=-1
+(.absdx)
+	@std.line.sy
+	M=1
+	@std.line.y
+	D=M
+	@std.line.y1
+	D=M-D
+	@std.line.dy
+	M=D
+	@.absdy
+	D;JGE
+	@std.line.dy
+	M=-M
+	@std.line.sy
+	M=-1
+(.absdy)
+	@std.line.dy
+	M=-M
+	@std.line.dx
+	D=M
+	@std.line.dy
+	D=D+M
+	@std.line.err
+	M=D
+
+(.loop)
+	@std.line.x
+	D=M
+	@std.a0
+	M=D
+	@std.line.y
+	D=M
+	@std.a1
+	M=D
+	@std.a2
+	M=1
+	@.plotted
+	D=A
+	@std.ret
+	M=D
+	@std.pixel
+	0;JMP
+(.plotted)
+	@std.line.x
+	D=M
+	@std.line.x1
+	D=D-M
+	@.step
+	D;JNE
+	@std.line.y
+	D=M
+	@std.line.y1
+	D=D-M
+	@.done
+	D;JEQ
+(.step)
+	@std.line.err
+	D=M
+	D=D+M
+	@std.line.e2
+	M=D
+	@std.line.dy
+	D=D-M
+	@.stepy
+	D;JLT
+	@std.line.dy
+	D=M
+	@std.line.err
+	M=D+M
+	@std.line.sx
+	D=M
+	@std.line.x
+	M=D+M
+(.stepy)
+	@std.line.e2
+	D=M
+	@std.line.dx
+	D=D-M
+	@.loop
+	D;JGT
+	@std.line.dx
+	D=M
+	@std.line.err
+	M=D+M
+	@std.line.sy
+	D=M
+	@std.line.y
+	M=D+M
+	@.loop
+	0;JMP
+(.done)
+	@std.line.ret
+	A=M
+	0;JMP
@@ -0,0 +1,48 @@
+// std.memcpy copies std.a2 words starting at address std.a1 to address std.a0. Words are copied
+// in ascending order so the destination must not start within the source. std.memset sets
+// std.a2 words starting at address std.a0 to std.a1.
+
+(std.memcpy)
+	@std.a2
+	D=M
+	@.done
+	D;JLE
+	@std.a2
+	M=D-1
+	@std.a1
+	A=M
+	D=M
+	@std.a0
+	A=M
+	M=D
+	@std.a0
+	M=M+1
+	@std.a1
+	M=M+1
+	@std.memcpy
+	0;JMP
+(.done)
+	@std.ret
+	A=M
+	0;JMP
+
+(std.memset)
+	@std.a2
+	D=M
+	@.done
+	D;JLE
+	@std.a2
+	M=D-1
+	@std.a1
+	D=M
+	@std.a0
+	A=M
+	M=D
+	@std.a0
+	M=M+1
+	@std.memset
+	0;JMP
+(.done)
+	@std.ret
+	A=M
+	0;JMP
@@ -0,0 +1,42 @@
+// std.mul returns std.a0 * std.a1 truncated to 16 bits. It works for signed and unsigned values.
+.var std.mul.result
+.var std.mul.x
+.var std.mul.bit
+
+(std.mul)
+	@std.mul.result
+	M=0
+	@std.a0
+	D=M
+	@std.mul.x
+	M=D
+	@std.mul.bit
+	M=1
+(.loop) // add x shifted by the position of every bit set in std.a1
+	@std.mul.bit
+	D=M
+	@.done
+	D;JEQ
+	@std.a1
+	D=D&M
+	@.next
+	D;JEQ
+	@std.mul.x
+	D=M
+	@std.mul.result
+	M=D+M
+(.next)
+	@std.mul.x
+	D=M
+	M=D+M
+	@std.mul.bit
+	D=M
+	M=D+M
+	@.loop
+	0;JMP
+(.done)
+	@std.mul.result
+	D=M
+	@std.ret
+	A=M
+	0;JMP
@@ -0,0 +1,95 @@
+// std.clear clears the screen. std.pixel draws the pixel at column std.a0 and row std.a1 in
+// black if std.a2 is not 0 and in white otherwise. Columns range from 0 to 511 and rows from 0 to
+// 255.
+.var std.pixel.mask
+
+(std.clear)
+	@SCREEN
+	D=A
+	@std.a0
+	M=D
+(.loop)
+	@std.a0
+	D=M
+	@KBD
+	D=D-A
+	@.done
+	D;JGE
+	@std.a0
+	A=M
+	M=0
+	@std.a0
+	M=M+1
+	@.loop
+	0;JMP
+(.done)
+	@std.ret
+	A=M
+	0;JMP
+
+(std.pixel) // the pixel is bit column%16 of the word at SCREEN + row*32 + column/16
+	@std.a1
+	D=M
+	M=D+M
+	D=M
+	M=D+M
+	D=M
+	M=D+M
+	D=M
+	M=D+M
+	D=M
+	M=D+M
+	@SCREEN
+	D=A
+	@std.a1
+	M=D+M
+(.column)
+	@16
+	D=A
+	@std.a0
+	D=M-D
+	@.bit
+	D;JLT
+	@std.a0
+	M=D
+	@std.a1
+	M=M+1
+	@.column
+	0;JMP
+(.bit)
+	@std.pixel.mask
+	M=1
+(.shift)
+	@std.a0
+	D=M
+	@.draw
+	D;JEQ
+	@std.a0
+	M=D-1
+	@std.pixel.mask
+	D=M
+	M=D+M
+	@.shift
+	0;JMP
+(.draw)
+	@std.a2
+	D=M
+	@.white
+	D;JEQ
+	@std.pixel.mask
+	D=M
+	@std.a1
+	A=M
+	M=D|M
+	@.done
+	0;JMP
+(.white)
+	@std.pixel.mask
+	D=!M
+	@std.a1
+	A=M
+	M=D&M
+(.done)
+	@std.ret
+	A=M
+	0;JMP
@@ -0,0 +1,58 @@
+// std.shl returns std.a0 shifted left by std.a1 bits. std.shr returns std.a0 shifted right by
+// std.a1 bits filling in zeros. The shift count needs to be between 0 and 16.
+.var std.shr.result
+
+(std.shl)
+	@std.a1
+	D=M
+	@.done
+	D;JLE
+	@std.a1
+	M=D-1
+	@std.a0
+	D=M
+	M=D+M
+	@std.shl
+	0;JMP
+(.done)
+	@std.a0
+	D=M
+	@std.ret
+	A=M
+	0;JMP
+
+(std.shr) // shift the upper 16-std.a1 bits of std.a0 into the result one at a time
+	@16
+	D=A
+	@std.a1
+	M=D-M
+	@std.shr.result
+	M=0
+(.loop)
+	@std.a1
+	D=M
+	@.done
+	D;JLE
+	@std.a1
+	M=D-1
+	@std.shr.result
+	D=M
+	M=D+M
+	@std.a0
+	D=M
+	@.shifted
+	D;JGE
+	@std.shr.result
+	M=M+1
+(.shifted)
+	@std.a0
+	D=M
+	M=D+M
+	@.loop
+	0;JMP
+(.done)
+	@std.shr.result
+	D=M
+	@std.ret
+	A=M
+	0;JMP
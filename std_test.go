@@ -0,0 +1,243 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// values are the arguments routines of the standard library are tested with.
+var values = []int16{-32768, -32767, -1000, -7, -6, -1, 0, 1, 2, 6, 7, 43, 1000, 32767}
+
+func TestStdArithmetic(t *testing.T) {
+	tests := map[string]func(x, y int16) int16{
+		"std.mul": func(x, y int16) int16 { return x * y },
+		"std.div": func(x, y int16) int16 {
+			if y == 0 {
+				return 0
+			}
+			if x == -32768 && y == -1 {
+				return x
+			}
+			return x / y
+		},
+		"std.mod": func(x, y int16) int16 {
+			if y == 0 || y == -1 {
+				return 0
+			}
+			return x % y
+		},
+	}
+
+	for routine, want := range tests {
+		t.Run(routine, func(t *testing.T) {
+			rom := stdProgram(t, routine)
+			for _, x := range values {
+				for _, y := range values {
+					c := callStd(t, rom, x, y)
+
+					if got := int16(c.RAM[0]); got != want(x, y) {
+						t.Errorf("%s(%d, %d) = %d; want %d", routine, x, y, got, want(x, y))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStdShift(t *testing.T) {
+	tests := map[string]func(x uint16, n int) uint16{
+		"std.shl": func(x uint16, n int) uint16 { return x << n },
+		"std.shr": func(x uint16, n int) uint16 { return x >> n },
+	}
+
+	for routine, want := range tests {
+		t.Run(routine, func(t *testing.T) {
+			rom := stdProgram(t, routine)
+			for _, x := range values {
+				for n := 0; n <= 16; n++ {
+					c := callStd(t, rom, x, int16(n))
+
+					if got := c.RAM[0]; got != want(uint16(x), n) {
+						t.Errorf("%s(%d, %d) = %d; want %d", routine, x, n, got, want(uint16(x), n))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStdMemory(t *testing.T) {
+	t.Run("std.memcpy", func(t *testing.T) {
+		rom := stdProgram(t, "std.memcpy")
+		c := &cpu{ROM: rom}
+		for i := uint16(0); i < 4; i++ {
+			c.RAM[1000+i] = i + 1
+		}
+		call(t, c, 2000, 1000, 3)
+
+		assertDeepEquals(t, "std.memcpy", "", c.RAM[2000:2004], []uint16{1, 2, 3, 0})
+	})
+
+	t.Run("std.memset", func(t *testing.T) {
+		rom := stdProgram(t, "std.memset")
+		c := &cpu{ROM: rom}
+		call(t, c, 1000, -1, 3)
+
+		assertDeepEquals(t, "std.memset", "", c.RAM[999:1004], []uint16{0, 0xFFFF, 0xFFFF, 0xFFFF, 0})
+	})
+}
+
+func TestStdScreen(t *testing.T) {
+	screen := int(predefinedSymbols["SCREEN"])
+
+	t.Run("std.clear", func(t *testing.T) {
+		rom := stdProgram(t, "std.clear")
+		c := &cpu{ROM: rom}
+		c.RAM[screen] = 0xFFFF
+		c.RAM[screen+8191] = 0xFFFF
+		c.RAM[screen+8192] = 7
+		call(t, c)
+
+		assertEquals(t, "std.clear", "", uint16(0), c.RAM[screen])
+		assertEquals(t, "std.clear", "", uint16(0), c.RAM[screen+8191])
+		assertEquals(t, "std.clear", "", uint16(7), c.RAM[screen+8192])
+	})
+
+	t.Run("std.pixel", func(t *testing.T) {
+		rom := stdProgram(t, "std.pixel")
+		c := &cpu{ROM: rom}
+		call(t, c, 0, 0, 1)
+		call(t, c, 15, 0, 1)
+		call(t, c, 17, 1, 1)
+		call(t, c, 511, 255, 1)
+
+		assertEquals(t, "std.pixel", "", uint16(0x8001), c.RAM[screen])
+		assertEquals(t, "std.pixel", "", uint16(0b10), c.RAM[screen+33])
+		assertEquals(t, "std.pixel", "", uint16(0x8000), c.RAM[screen+8191])
+
+		call(t, c, 0, 0, 0)
+		assertEquals(t, "std.pixel", "", uint16(0x8000), c.RAM[screen])
+	})
+
+	t.Run("std.line", func(t *testing.T) {
+		tests := map[string]struct {
+			x0, y0, x1, y1 int16
+			want           [][2]int
+		}{
+			"Point": {
+				x0: 3, y0: 4, x1: 3, y1: 4,
+				want: [][2]int{{3, 4}},
+			},
+			"Horizontal": {
+				x0: 18, y0: 2, x1: 14, y1: 2,
+				want: [][2]int{{14, 2}, {15, 2}, {16, 2}, {17, 2}, {18, 2}},
+			},
+			"Diagonal": {
+				x0: 0, y0: 3, x1: 3, y1: 0,
+				want: [][2]int{{0, 3}, {1, 2}, {2, 1}, {3, 0}},
+			},
+			"Steep": {
+				x0: 0, y0: 0, x1: 1, y1: 3,
+				want: [][2]int{{0, 0}, {0, 1}, {1, 2}, {1, 3}},
+			},
+		}
+
+		rom := stdProgram(t, "std.line")
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				c := &cpu{ROM: rom}
+				call(t, c, tc.x0, tc.y0, tc.x1, tc.y1)
+
+				var want [ramSize]uint16
+				for _, p := range tc.want {
+					x, y := p[0], p[1]
+					want[screen+y*32+x/16] |= 1 << (x % 16)
+				}
+				assertDeepEquals(t, "std.line", name, c.RAM[screen:screen+8192], want[screen:screen+8192])
+			})
+		}
+	})
+}
+
+func TestStdIsIncludedOnDemand(t *testing.T) {
+	in := `
+	@std.ret
+	@std.mul
+`
+	var listing strings.Builder
+	asm := Assembler{Listing: &listing, Strict: true}
+	err := asm.Assemble(strings.NewReader(in), new(strings.Builder))
+	assertNoError(t, err)
+
+	for _, file := range []string{"std/abi.asm", "std/mul.asm"} {
+		if !strings.Contains(listing.String(), file) {
+			t.Errorf("Assemble(%q) did not include %s", in, file)
+		}
+	}
+	if strings.Contains(listing.String(), "std/div.asm") {
+		t.Errorf("Assemble(%q) included std/div.asm which is not referenced", in)
+	}
+
+	in = `
+(std.mul)
+	@std.mul
+`
+	var got bytes.Buffer
+	err = Assemble(strings.NewReader(in), &got)
+	assertNoError(t, err)
+	assertDeepEquals(t, "Assemble", in, got.String(), "0000000000000000\n")
+
+	err = Assemble(strings.NewReader("@std.missing"), new(strings.Builder))
+	assertError(t, err)
+}
+
+// stdProgram assembles a program calling the routine of the standard library and storing its
+// result in R0. The arguments need to be stored in std.a0 to std.a3 before running it.
+func stdProgram(t *testing.T, routine string) []uint16 {
+	t.Helper()
+
+	in := `
+	@RET
+	D=A
+	@std.ret
+	M=D
+	@` + routine + `
+	0;JMP
+(RET)
+	@R0
+	M=D
+(END)
+	@END
+	0;JMP
+`
+	var machine bytes.Buffer
+	err := Assemble(strings.NewReader(in), &machine)
+	assertNoError(t, err)
+
+	rom, err := readProgram(&machine)
+	assertNoError(t, err)
+	return rom
+}
+
+// callStd runs the program with given arguments on a new cpu.
+func callStd(t *testing.T, rom []uint16, args ...int16) *cpu {
+	t.Helper()
+
+	c := &cpu{ROM: rom}
+	call(t, c, args...)
+	return c
+}
+
+// call runs the program from the start with given arguments.
+func call(t *testing.T, c *cpu, args ...int16) {
+	t.Helper()
+
+	for i, arg := range args {
+		c.RAM[predefinedSymbols["R5"]+uint16(i)] = uint16(arg)
+	}
+	c.PC, c.Cycles = 0, 0
+	if !c.run(1_000_000) {
+		t.Fatalf("call(%v) did not halt", args)
+	}
+}
@@ -0,0 +1,346 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Superoptimizer searches for the shortest sequences of instructions that are equivalent to a short
+// snippet of straight-line hack assembly. The zero value is ready to use.
+//
+// Candidates are enumerated from the C-instructions of every comp and dest, the jumps if the snippet
+// jumps, and the A-instructions of the snippet. Two sequences are equivalent if they leave the same
+// values in A, D and RAM and jump to the same address, if any, for every initial state that is
+// evaluated. Initial states assign A, D, RAM and the symbols of the snippet either random values or
+// values like 0, -1 and 0x8000 that tend to reveal differences. Equivalence is thus tested and not
+// proven.
+type Superoptimizer struct {
+	// MaxLength is the maximum number of instructions of a candidate. It defaults to 2. The search
+	// space grows by a factor of about 200 with every instruction.
+	MaxLength int
+	// Tests is the number of random initial states every equivalent candidate is verified on. It
+	// defaults to 10000.
+	Tests int
+	// Dead lists the registers A or D whose value after the snippet is not used. Candidates are
+	// allowed to leave any value in them.
+	Dead string
+	// Seed seeds the random initial states.
+	Seed int64
+}
+
+// corners are values that are tried as the initial value of every input on top of random values.
+var corners = []uint16{0, 1, 2, 0x7fff, 0x8000, 0xfffe, 0xffff}
+
+// Search reads a snippet of hack assembly from r and writes every shortest equivalent sequence that
+// is shorter than the snippet to w. The snippet can only consist of A- and C-instructions and can
+// only jump in its last instruction.
+func (s *Superoptimizer) Search(r io.Reader, w io.Writer) error {
+	snippet, err := new(Assembler).parse(r, "")
+	if err != nil {
+		return err
+	}
+	program, err := newSnippet(snippet)
+	if err != nil {
+		return err
+	}
+	maxLength := s.MaxLength
+	if maxLength == 0 {
+		maxLength = 2
+	}
+	tests := s.Tests
+	if tests == 0 {
+		tests = 10000
+	}
+	for _, r := range s.Dead {
+		if r != 'A' && r != 'D' && r != ',' && r != ' ' {
+			return fmt.Errorf("failed to superoptimize: invalid dead register %q, expected A or D", r)
+		}
+	}
+
+	states := program.states(rand.New(rand.NewSource(s.Seed)), tests)
+	wants := make([]outcome, len(states))
+	for i, st := range states {
+		wants[i] = evaluate(program.steps, st)
+	}
+	alphabet := program.alphabet()
+	compare := func(candidate []step) bool {
+		for i, st := range states {
+			if !equivalent(wants[i], evaluate(candidate, st), st, s.Dead) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for length := 1; length < len(program.steps) && length <= maxLength; length++ {
+		var found [][]step
+		enumerate(alphabet, length, program.jumps, func(candidate []step) {
+			if compare(candidate) {
+				found = append(found, append([]step(nil), candidate...))
+			}
+		})
+		if len(found) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "// %d instead of %d instructions, verified on %d initial states\n", length, len(program.steps), len(states))
+		for i, candidate := range found {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			for _, st := range candidate {
+				fmt.Fprintf(w, "\t%s\n", st.ins)
+			}
+		}
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "// no equivalent sequence of less than %d instructions found\n", min(len(program.steps), maxLength+1))
+	return err
+}
+
+// step is an instruction of a snippet or candidate together with what is needed to evaluate it.
+type step struct {
+	ins instruction
+	// code is the machine code of a C-instruction.
+	code uint16
+	// input is the index of the value an A-instruction loads into the inputs of a state, -1 for
+	// C-instructions.
+	input int
+}
+
+// snippet is a snippet of straight-line code that is superoptimized.
+type snippet struct {
+	steps []step
+	// loads are the A-instructions of the snippet. Symbols are inputs while numbers are constant.
+	loads []*aInstruction
+	// jumps is true if the last instruction of the snippet jumps.
+	jumps bool
+}
+
+func newSnippet(instructions []instruction) (*snippet, error) {
+	var s snippet
+	loads := make(map[string]int)
+	for _, ins := range instructions {
+		switch ins := ins.(type) {
+		case *aInstruction:
+			if _, ok := loads[ins.String()]; !ok {
+				loads[ins.String()] = len(s.loads)
+				s.loads = append(s.loads, ins)
+			}
+			s.steps = append(s.steps, step{ins: ins, input: loads[ins.String()]})
+		case *cInstruction:
+			code, err := encodeCInstruction(ins)
+			if err != nil {
+				return nil, err
+			}
+			if s.jumps {
+				return nil, errorf(ins.Pos, "failed to superoptimize: only the last instruction can jump")
+			}
+			s.jumps = ins.Jump != ""
+			s.steps = append(s.steps, step{ins: ins, code: code, input: -1})
+		default:
+			return nil, fmt.Errorf("failed to superoptimize %s: a snippet can only consist of A- and C-instructions", ins)
+		}
+	}
+	if len(s.steps) == 0 {
+		return nil, errors.New("failed to superoptimize: snippet is empty")
+	}
+	return &s, nil
+}
+
+// encodeCInstruction translates a C-instruction into machine code.
+func encodeCInstruction(c *cInstruction) (uint16, error) {
+	code, err := codeCInstruction(c)
+	if err != nil {
+		return 0, errorf(c.Pos, "failed to encode c-instruction %q: %v", c, err)
+	}
+	v, err := strconv.ParseUint(string(code), 2, 16)
+	return uint16(v), err
+}
+
+// alphabet returns the instructions candidates are made of. Jumps are enumerated separately.
+func (s *snippet) alphabet() []step {
+	var alphabet []step
+	for i, load := range s.loads {
+		alphabet = append(alphabet, step{ins: load, input: i})
+	}
+	// a computation that is neither stored nor jumped on has no effect so every C-instruction has a
+	// dest
+	for _, comp := range sortedKeys(compToC) {
+		for _, dest := range sortedKeys(destToD) {
+			c := &cInstruction{Dest: dest, Comp: comp}
+			code, _ := encodeCInstruction(c)
+			alphabet = append(alphabet, step{ins: c, code: code, input: -1})
+		}
+	}
+	return alphabet
+}
+
+// enumerate calls f with every sequence of length steps of the alphabet. If jumps is true the last
+// instruction of every sequence is a C-instruction that jumps.
+func enumerate(alphabet []step, length int, jumps bool, f func([]step)) {
+	candidate := make([]step, length)
+	var jumpSteps []step
+	if jumps {
+		for _, comp := range sortedKeys(compToC) {
+			for _, dest := range append([]string{""}, sortedKeys(destToD)...) {
+				for _, jump := range sortedKeys(jumpToJ) {
+					c := &cInstruction{Dest: dest, Comp: comp, Jump: jump}
+					code, _ := encodeCInstruction(c)
+					jumpSteps = append(jumpSteps, step{ins: c, code: code, input: -1})
+				}
+			}
+		}
+	}
+
+	var fill func(i int)
+	fill = func(i int) {
+		choices := alphabet
+		if jumps && i == length-1 {
+			choices = jumpSteps
+		}
+		for _, st := range choices {
+			candidate[i] = st
+			if i == length-1 {
+				f(candidate)
+			} else {
+				fill(i + 1)
+			}
+		}
+	}
+	fill(0)
+}
+
+// state is an initial state of the hack computer.
+type state struct {
+	A, D uint16
+	// inputs are the values loaded by the A-instructions of the snippet.
+	inputs []uint16
+	// seed determines the initial value of every RAM address.
+	seed uint64
+}
+
+// states returns the initial states candidates are evaluated on. The first states combine values
+// that tend to reveal differences.
+func (s *snippet) states(rng *rand.Rand, n int) []state {
+	var states []state
+	inputs := func() []uint16 {
+		values := make([]uint16, len(s.loads))
+		for i, load := range s.loads {
+			if load.IsSymbol || load.Expr != nil {
+				values[i] = uint16(rng.Intn(1 << 15))
+			} else {
+				values[i] = load.Value
+			}
+		}
+		return values
+	}
+	for _, a := range corners {
+		for _, d := range corners {
+			states = append(states, state{A: a, D: d, inputs: inputs(), seed: rng.Uint64()})
+		}
+	}
+	// most candidates differ on the first few states, which is faster to find out if they vary
+	rng.Shuffle(len(states), func(i, j int) { states[i], states[j] = states[j], states[i] })
+	for i := 0; i < n; i++ {
+		states = append(states, state{A: uint16(rng.Uint32()), D: uint16(rng.Uint32()), inputs: inputs(), seed: rng.Uint64()})
+	}
+	return states
+}
+
+// outcome is the effect of evaluating a sequence of instructions on a state.
+type outcome struct {
+	A, D    uint16
+	jumped  bool
+	written []write
+}
+
+type write struct {
+	address, value uint16
+}
+
+// ram returns the initial value of address in a state with given seed.
+func ram(seed uint64, address uint16) uint16 {
+	x := seed ^ uint64(address)*0x9e3779b97f4a7c15
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	return uint16(x >> 16)
+}
+
+// evaluate executes the steps on the state until a jump is taken.
+func evaluate(steps []step, st state) outcome {
+	o := outcome{A: st.A, D: st.D}
+	read := func(address uint16) uint16 {
+		for i := len(o.written) - 1; i >= 0; i-- {
+			if o.written[i].address == address {
+				return o.written[i].value
+			}
+		}
+		return ram(st.seed, address)
+	}
+
+	for _, s := range steps {
+		if s.input >= 0 {
+			o.A = st.inputs[s.input]
+			continue
+		}
+		address := o.A & (ramSize - 1)
+		y := o.A
+		if s.code&0x1000 != 0 {
+			y = read(address)
+		}
+		out := alu(o.D, y, s.code>>6&0x3f)
+		if s.code&0x20 != 0 {
+			o.A = out
+		}
+		if s.code&0x10 != 0 {
+			o.D = out
+		}
+		if s.code&0x08 != 0 {
+			o.written = append(o.written, write{address: address, value: out})
+		}
+		if jumps(int16(out), s.code&0x7) {
+			o.jumped = true
+			break
+		}
+	}
+	return o
+}
+
+// equivalent returns true if a candidate had the same effect on the state as the snippet. Registers
+// listed in dead are not compared unless a jump is taken as A is the target of the jump.
+func equivalent(want, got outcome, st state, dead string) bool {
+	if want.jumped != got.jumped {
+		return false
+	}
+	if (want.jumped || !strings.Contains(dead, "A")) && want.A != got.A {
+		return false
+	}
+	if !strings.Contains(dead, "D") && want.D != got.D {
+		return false
+	}
+	return sameRAM(want.written, got.written, st.seed) && sameRAM(got.written, want.written, st.seed)
+}
+
+// sameRAM returns true if every address written in a holds the same final value in b.
+func sameRAM(a, b []write, seed uint64) bool {
+	final := func(writes []write, address uint16) uint16 {
+		for i := len(writes) - 1; i >= 0; i-- {
+			if writes[i].address == address {
+				return writes[i].value
+			}
+		}
+		return ram(seed, address)
+	}
+	for _, w := range a {
+		if final(a, w.address) != final(b, w.address) {
+			return false
+		}
+	}
+	return true
+}
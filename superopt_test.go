@@ -0,0 +1,114 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSuperoptimize(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		dead string
+		want string
+	}{
+		"IncrementVariable": {
+			in: `
+	@x
+	D=M
+	D=D+1
+	@x
+	M=D
+`,
+			want: `// 2 instead of 5 instructions, verified on 10049 initial states
+	@x
+	MD=M+1
+`,
+		},
+		"LoadConstant": {
+			in: `
+	@0
+	D=A
+`,
+			want: `// 1 instead of 2 instructions, verified on 10049 initial states
+	AD=0
+`,
+		},
+		"LoadConstantWithDeadA": {
+			in: `
+	@0
+	D=A
+`,
+			dead: "A",
+			want: `// 1 instead of 2 instructions, verified on 10049 initial states
+	AD=0
+
+	D=0
+`,
+		},
+		"Jump": {
+			in: `
+	D=0
+	@END
+	0;JMP
+`,
+			want: `// 2 instead of 3 instructions, verified on 10049 initial states
+	@END
+	D=0;JEQ
+
+	@END
+	D=0;JGE
+
+	@END
+	D=0;JLE
+
+	@END
+	D=0;JMP
+`,
+		},
+		"NoShorterSequence": {
+			in: `
+	@x
+	M=D
+`,
+			want: "// no equivalent sequence of less than 2 instructions found\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got strings.Builder
+			s := Superoptimizer{Dead: tc.dead}
+			err := s.Search(strings.NewReader(tc.in), &got)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Search", tc.in, got.String(), tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in   string
+		dead string
+	}{
+		"RejectLabel": {
+			in: "(LOOP)\n\tD=D-1",
+		},
+		"RejectJumpBeforeLastInstruction": {
+			in: "\t@END\n\t0;JMP\n\tD=0",
+		},
+		"RejectEmptySnippet": {
+			in: "// nothing",
+		},
+		"RejectInvalidDeadRegister": {
+			in:   "\tD=0",
+			dead: "M",
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			s := Superoptimizer{Dead: tc.dead}
+			err := s.Search(strings.NewReader(tc.in), new(strings.Builder))
+			assertError(t, err)
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package hack
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// symbolKind classifies user-defined symbols.
+type symbolKind int
+
+const (
+	labelSymbol symbolKind = iota
+	variableSymbol
+	constantSymbol
+	blockSymbol
+)
+
+func (k symbolKind) String() string {
+	switch k {
+	case labelSymbol:
+		return "label"
+	case variableSymbol:
+		return "variable"
+	case constantSymbol:
+		return "constant"
+	case blockSymbol:
+		return "block"
+	}
+	return "unknown"
+}
+
+// symbol is a user-defined symbol and the address it was resolved to. The position is that of the
+// declaration or of the first reference for variables that are not declared.
+type symbol struct {
+	Name  string
+	Value uint16
+	Kind  symbolKind
+	Pos   pos
+}
+
+// writeSymbols writes the symbol table to w with one symbol per line.
+func writeSymbols(w io.Writer, symbols []symbol) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range symbols {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", s.Name, s.Value, s.Kind)
+	}
+	return tw.Flush()
+}
+
+// writeMemoryMap writes the RAM regions to w ordered by address with one region per line.
+func writeMemoryMap(w io.Writer, regions []region) error {
+	regions = append([]region(nil), regions...)
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].Address < regions[j].Address
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, r := range regions {
+		fmt.Fprintf(tw, "%d-%d\t%d\t%s\t%s\t%s\n", r.Address, r.end()-1, r.Size, r.Name, r.Kind, r.Pos)
+	}
+	return tw.Flush()
+}
+
+// symbolTable resolves symbols into addresses or values. Labels, constants and declared variables
+// are added using declare. Symbols that are referenced but not declared are allocated as variables
+// unless the table is strict.
+type symbolTable struct {
+	values map[string]uint16
+	// symbols holds the user-defined symbols in the order they were declared or allocated.
+	symbols []symbol
+	// declared holds the user-defined symbols by name.
+	declared map[string]symbol
+	used     map[string]bool
+	ram      *allocator
+	// strict rejects references to undeclared symbols instead of allocating them as variables.
+	strict bool
+}
+
+// declare resolves all labels, constants and declared variables in instructions.
+func declare(instructions []instruction, strict bool) (*symbolTable, error) {
+	t := &symbolTable{
+		values:   make(map[string]uint16),
+		declared: make(map[string]symbol),
+		used:     make(map[string]bool),
+		ram:      newAllocator(),
+		strict:   strict,
+	}
+
+	var pc int
+	var constants []*constant
+	var variables []instruction
+	for _, instruction := range instructions {
+		switch v := instruction.(type) {
+		case *label:
+			if err := t.checkDeclaration("label", v.Literal, v.Pos); err != nil {
+				return nil, err
+			}
+			t.add(symbol{Name: v.Literal, Value: uint16(pc), Kind: labelSymbol, Pos: v.Pos})
+		case *constant:
+			if err := t.checkDeclaration("constant", v.Name, v.Pos); err != nil {
+				return nil, err
+			}
+			t.declared[v.Name] = symbol{Name: v.Name, Kind: constantSymbol, Pos: v.Pos}
+			constants = append(constants, v)
+		case *variable:
+			if err := t.checkDeclaration("variable", v.Name, v.Pos); err != nil {
+				return nil, err
+			}
+			t.declared[v.Name] = symbol{Name: v.Name, Kind: variableSymbol, Pos: v.Pos}
+			variables = append(variables, v)
+		case *array:
+			if err := t.checkDeclaration("block", v.Name, v.Pos); err != nil {
+				return nil, err
+			}
+			t.declared[v.Name] = symbol{Name: v.Name, Kind: blockSymbol, Pos: v.Pos}
+			variables = append(variables, v)
+		case *placement:
+			n, err := v.padding(pc)
+			if err != nil {
+				return nil, err
+			}
+			pc += n
+		case *aInstruction, *cInstruction, *word:
+			pc++
+		}
+	}
+	for k, v := range predefinedSymbols {
+		t.values[k] = v
+	}
+
+	if err := resolveConstants(constants, t.values); err != nil {
+		return nil, err
+	}
+	for _, c := range constants {
+		t.add(symbol{Name: c.Name, Value: t.values[c.Name], Kind: constantSymbol, Pos: c.Pos})
+	}
+
+	// variables at fixed addresses are reserved first so other variables and blocks are allocated
+	// around them
+	for _, ins := range variables {
+		v, ok := ins.(*variable)
+		if !ok || v.Address == nil {
+			continue
+		}
+		address, err := evalConstant(v.Address, t.lookup)
+		if err != nil {
+			return nil, errorf(v.Pos, "failed to declare variable %q: %v", v.Name, err)
+		}
+		r := region{Name: v.Name, Address: address, Size: 1, Kind: variableSymbol, Pos: v.Pos}
+		if err := t.ram.reserve(r); err != nil {
+			return nil, errorf(v.Pos, "failed to declare variable %q: %v", v.Name, err)
+		}
+		t.add(symbol{Name: v.Name, Value: address, Kind: variableSymbol, Pos: v.Pos})
+	}
+	for _, ins := range variables {
+		switch v := ins.(type) {
+		case *variable:
+			if v.Address != nil {
+				continue
+			}
+			address, err := t.ram.allocate(v.Name, 1, variableSymbol, v.Pos)
+			if err != nil {
+				return nil, errorf(v.Pos, "failed to declare variable %q: %v", v.Name, err)
+			}
+			t.add(symbol{Name: v.Name, Value: address, Kind: variableSymbol, Pos: v.Pos})
+		case *array:
+			size, err := evalConstant(v.Size, t.lookup)
+			if err != nil {
+				return nil, errorf(v.Pos, "failed to declare block %q: %v", v.Name, err)
+			}
+			if size == 0 {
+				return nil, errorf(v.Pos, "failed to declare block %q: size must be at least 1", v.Name)
+			}
+			address, err := t.ram.allocate(v.Name, size, blockSymbol, v.Pos)
+			if err != nil {
+				return nil, errorf(v.Pos, "failed to declare block %q: %v", v.Name, err)
+			}
+			t.add(symbol{Name: v.Name, Value: address, Kind: blockSymbol, Pos: v.Pos})
+		}
+	}
+
+	return t, nil
+}
+
+// checkDeclaration returns an error if name cannot be declared as a symbol of given kind.
+func (t *symbolTable) checkDeclaration(kind, name string, p pos) error {
+	if prev, ok := t.declared[name]; ok {
+		if prev.Kind.String() == kind {
+			return errorf(p, "failed to encode %s %q: %s re-declared, previous declaration at %s", kind, name, kind, prev.Pos)
+		}
+		return errorf(p, "failed to encode %s %q: symbol already declared as %s at %s", kind, name, prev.Kind, prev.Pos)
+	}
+	if _, ok := predefinedSymbols[name]; ok {
+		return errorf(p, "failed to encode %s: %q is a pre-defined symbol which cannot be used as a %s", kind, name, kind)
+	}
+	return nil
+}
+
+// add adds the resolved symbol s.
+func (t *symbolTable) add(s symbol) {
+	t.values[s.Name] = s.Value
+	t.declared[s.Name] = s
+	t.symbols = append(t.symbols, s)
+}
+
+// lookup returns the value of symbol which must have been declared or allocated.
+func (t *symbolTable) lookup(symbol string) (int, error) {
+	v, ok := t.values[symbol]
+	if !ok {
+		return 0, fmt.Errorf("undefined symbol %q", symbol)
+	}
+	t.used[symbol] = true
+	return int(v), nil
+}
+
+// value returns the value loaded by the A-instruction. A symbol that has not been declared is
+// allocated as a variable unless the table is strict.
+func (t *symbolTable) value(ins *aInstruction) (uint16, error) {
+	if ins.Expr != nil {
+		v, err := evalConstant(ins.Expr, t.lookup)
+		if err != nil {
+			return 0, errorf(ins.Pos, "failed to encode A-instruction \"@%s\": %v", ins.Literal, err)
+		}
+		return v, nil
+	}
+	if !ins.IsSymbol {
+		return ins.Value, nil
+	}
+
+	t.used[ins.Literal] = true
+	if v, ok := t.values[ins.Literal]; ok {
+		return v, nil
+	}
+	if t.strict {
+		return 0, errorf(ins.Pos, "failed to encode A-instruction %q: undeclared symbol %q. Declare variables using .var", ins, ins.Literal)
+	}
+	v, err := t.ram.allocate(ins.Literal, 1, variableSymbol, ins.Pos)
+	if err != nil {
+		return 0, errorf(ins.Pos, "failed to allocate variable %q: %v", ins.Literal, err)
+	}
+	t.add(symbol{Name: ins.Literal, Value: v, Kind: variableSymbol, Pos: ins.Pos})
+	return v, nil
+}
+
+// unusedVariables returns the variables and blocks that were declared using .var or .block but
+// never referenced.
+func (t *symbolTable) unusedVariables(instructions []instruction) []symbol {
+	var unused []symbol
+	for _, instruction := range instructions {
+		var name string
+		switch v := instruction.(type) {
+		case *variable:
+			name = v.Name
+		case *array:
+			name = v.Name
+		default:
+			continue
+		}
+		if !t.used[name] {
+			unused = append(unused, t.declared[name])
+		}
+	}
+	return unused
+}
+
+// region is a contiguous region of RAM holding a variable or block.
+type region struct {
+	Name    string
+	Address uint16
+	Size    uint16
+	Kind    symbolKind
+	Pos     pos
+}
+
+// end returns the address following the region.
+func (r region) end() int {
+	return int(r.Address) + int(r.Size)
+}
+
+// overlaps returns true if the region of given size starting at address overlaps r.
+func (r region) overlaps(address, size int) bool {
+	return address < r.end() && int(r.Address) < address+size
+}
+
+// allocator allocates RAM for variables and blocks starting at address 16 up to the screen memory
+// map. Regions reserved for variables with a fixed address are skipped.
+type allocator struct {
+	next    int
+	regions []region
+}
+
+func newAllocator() *allocator {
+	return &allocator{next: 16}
+}
+
+// reserve reserves the region r at its fixed address.
+func (a *allocator) reserve(r region) error {
+	if r.end() > int(predefinedSymbols["SCREEN"]) {
+		return fmt.Errorf("address %d is outside of RAM: RAM ends where the screen memory map starts at %d", r.Address, predefinedSymbols["SCREEN"])
+	}
+	for _, other := range a.regions {
+		if other.overlaps(int(r.Address), int(r.Size)) {
+			return fmt.Errorf("address %d is already reserved for %s %q", r.Address, other.Kind, other.Name)
+		}
+	}
+	a.regions = append(a.regions, r)
+	return nil
+}
+
+// allocate allocates a region of given size at the next address that is not reserved.
+func (a *allocator) allocate(name string, size uint16, kind symbolKind, p pos) (uint16, error) {
+	address := a.next
+	for moved := true; moved; {
+		moved = false
+		for _, r := range a.regions {
+			if r.overlaps(address, int(size)) {
+				address, moved = r.end(), true
+			}
+		}
+	}
+	if end := address + int(size); end > int(predefinedSymbols["SCREEN"]) {
+		return 0, fmt.Errorf("not enough RAM for %d word(s) at address %d: RAM ends where the screen memory map starts at %d", size, address, predefinedSymbols["SCREEN"])
+	}
+
+	a.regions = append(a.regions, region{Name: name, Address: uint16(address), Size: size, Kind: kind, Pos: p})
+	a.next = address + int(size)
+	return uint16(address), nil
+}
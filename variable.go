@@ -0,0 +1,99 @@
+package hack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// variable represents the declaration of a variable using .var name. It is a pseudo-instruction that
+// will not be translated into machine code. Declared variables are allocated in RAM in the order
+// they are declared starting at address 16 like variables that are not declared. A variable can be
+// placed at a fixed address using .var name @ address in which case Address is not nil.
+type variable struct {
+	Name    string
+	Address expr
+	Pos     pos
+}
+
+func (v variable) Instruction() {}
+
+func (v variable) String() string {
+	if v.Address != nil {
+		return ".var " + v.Name + " @ " + v.Address.String()
+	}
+	return ".var " + v.Name
+}
+
+// parseVariable parses a variable declaration like .var name or .var name @ 100. The address is an
+// unsigned 15-bit constant, a symbol or an expression.
+func parseVariable(in string) (*variable, error) {
+	directive, rest := cutField(in)
+	if directive != ".var" {
+		return nil, errors.New("failed to parse variable: variable declarations need to start with .var")
+	}
+	name, address, hasAddress := strings.Cut(rest, "@")
+	name = strings.TrimSpace(name)
+	if !isSymbol(name) || isLocal(name) {
+		return nil, fmt.Errorf("failed to parse variable %q: name must be a symbol that does not begin with a digit or a dot", name)
+	}
+	v := &variable{Name: name}
+	if !hasAddress {
+		return v, nil
+	}
+
+	operand, err := parseAInstruction("@" + strings.TrimSpace(address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse variable %q: %v", name, err)
+	}
+	v.Address = operandExpr(operand)
+	return v, nil
+}
+
+// operandExpr returns the expression for the operand of an A-instruction.
+func operandExpr(a *aInstruction) expr {
+	if a.Expr != nil {
+		return a.Expr
+	}
+	if a.IsSymbol {
+		return symbolExpr{Name: a.Literal}
+	}
+	return numberExpr{Value: int(a.Value)}
+}
+
+// array represents the reservation of a contiguous region of RAM using .block name size. It is a
+// pseudo-instruction that will not be translated into machine code. Blocks are allocated by the
+// same allocator as variables. The name refers to the first address of the block.
+type array struct {
+	Name string
+	Size expr
+	Pos  pos
+}
+
+func (b array) Instruction() {}
+
+func (b array) String() string {
+	return ".block " + b.Name + " " + b.Size.String()
+}
+
+// parseArray parses a block declaration like .block name size. The size is an unsigned 15-bit
+// constant, a constant or an expression.
+func parseArray(in string) (*array, error) {
+	directive, rest := cutField(in)
+	if directive != ".block" {
+		return nil, errors.New("failed to parse block: block declarations need to start with .block")
+	}
+	name, size := cutField(rest)
+	if !isSymbol(name) || isLocal(name) {
+		return nil, fmt.Errorf("failed to parse block %q: name must be a symbol that does not begin with a digit or a dot", name)
+	}
+	if size == "" {
+		return nil, fmt.Errorf("failed to parse block %q: missing size", name)
+	}
+
+	operand, err := parseAInstruction("@" + size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse block %q: %v", name, err)
+	}
+	return &array{Name: name, Size: operandExpr(operand)}, nil
+}
@@ -0,0 +1,302 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseVariable(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want instruction
+	}{
+		"Variable": {
+			in: `.var count`,
+			want: &variable{
+				Name: "count",
+			},
+		},
+		"FixedAddress": {
+			in: `.var buffer @ 100`,
+			want: &variable{
+				Name:    "buffer",
+				Address: numberExpr{Value: 100},
+			},
+		},
+		"SymbolicAddress": {
+			in: `.var buffer @BASE`,
+			want: &variable{
+				Name:    "buffer",
+				Address: symbolExpr{Name: "BASE"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseVariable(tc.in)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "parseVariable", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingName": {
+			in: `.var`,
+		},
+		"RejectNameWithLeadingDigit": {
+			in: `.var 2count`,
+		},
+		"RejectLocalName": {
+			in: `.var .count`,
+		},
+		"RejectMissingAddress": {
+			in: `.var buffer @`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseVariable(tc.in)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleVariables(t *testing.T) {
+	in := `
+.equ BASE 16
+.var first
+.var fixed @ BASE
+.var second
+	@implicit
+	@second
+	@first
+	@fixed
+`
+	want := `0000000000010011
+0000000000010010
+0000000000010001
+0000000000010000
+`
+	var got bytes.Buffer
+	err := Assemble(strings.NewReader(in), &got)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Assemble", in, got.String(), want)
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectRedeclaration": {
+			in: `
+.var count
+.var count
+`,
+		},
+		"RejectClashWithLabel": {
+			in: `
+(count)
+.var count
+`,
+		},
+		"RejectClashWithConstant": {
+			in: `
+.var count
+.equ count 1
+`,
+		},
+		"RejectClashWithPredefinedSymbol": {
+			in: `.var R0`,
+		},
+		"RejectOverlappingFixedAddresses": {
+			in: `
+.var a @ 100
+.var b @ 100
+`,
+		},
+		"RejectAddressInScreenMemoryMap": {
+			in: `.var a @ SCREEN+1`,
+		},
+		"RejectAddressOfKeyboard": {
+			in: `.var a @ KBD`,
+		},
+		"RejectAddressAboveKeyboard": {
+			in: `.var a @ 24577`,
+		},
+		"RejectAddressExceeding15Bits": {
+			in: `.var a @ 0x8000`,
+		},
+		"RejectAddressReferringToUndefinedSymbol": {
+			in: `.var a @ BASE`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			err := Assemble(strings.NewReader(tc.in), new(strings.Builder))
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleStrict(t *testing.T) {
+	in := `
+.var count
+.equ ROWS 1
+(LOOP)
+	@count
+	@ROWS
+	@SCREEN
+	@LOOP
+`
+	asm := Assembler{Strict: true}
+	err := asm.Assemble(strings.NewReader(in), new(strings.Builder))
+	assertNoError(t, err)
+
+	in = `
+.var count
+	@count
+	@cuont
+`
+	err = asm.Assemble(strings.NewReader(in), new(strings.Builder))
+	assertError(t, err)
+
+	want := `line 4: failed to encode A-instruction "@cuont": undeclared symbol "cuont"`
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Assemble(%q) = %q; want error starting with %q", in, err, want)
+	}
+}
+
+func TestAssembleWarnsAboutUnusedVariables(t *testing.T) {
+	in := `
+.var used
+.var unused
+.var inExpression
+	@used
+	@inExpression+1
+`
+	var warnings strings.Builder
+	asm := Assembler{Warnings: &warnings}
+	err := asm.Assemble(strings.NewReader(in), new(strings.Builder))
+	assertNoError(t, err)
+
+	want := "line 3: warning: variable \"unused\" is declared but not used [unused-variable]\n"
+	assertDeepEquals(t, "Assemble", in, warnings.String(), want)
+}
+
+func TestParseArray(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want instruction
+	}{
+		"Array": {
+			in: `.block buffer 32`,
+			want: &array{
+				Name: "buffer",
+				Size: numberExpr{Value: 32},
+			},
+		},
+		"SizeExpression": {
+			in: `.block row, WIDTH/16`,
+			want: &array{
+				Name: "row",
+				Size: binaryExpr{Op: "/", X: symbolExpr{Name: "WIDTH"}, Y: numberExpr{Value: 16}},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseArray(tc.in)
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "parseArray", tc.in, got, tc.want)
+		})
+	}
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectMissingName": {
+			in: `.block`,
+		},
+		"RejectMissingSize": {
+			in: `.block buffer`,
+		},
+		"RejectLocalName": {
+			in: `.block .buffer 2`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseArray(tc.in)
+			assertError(t, err)
+		})
+	}
+}
+
+func TestAssembleArrays(t *testing.T) {
+	in := `
+.equ SIZE 4
+.var fixed @ 18
+.block buffer SIZE
+.var count
+	@buffer+3
+	@count
+	@next
+`
+	want := `0000000000010110
+0000000000010111
+0000000000011000
+`
+	var got bytes.Buffer
+	var memoryMap strings.Builder
+	asm := Assembler{MemoryMap: &memoryMap}
+	err := asm.Assemble(strings.NewReader(in), &got)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Assemble", in, got.String(), want)
+
+	wantMap := `18-18  1  fixed   variable  line 3
+19-22  4  buffer  block     line 4
+23-23  1  count   variable  line 5
+24-24  1  next    variable  line 8
+`
+	assertDeepEquals(t, "Assemble", in, memoryMap.String(), wantMap)
+
+	errTests := map[string]struct {
+		in string
+	}{
+		"RejectZeroSize": {
+			in: `.block buffer 0`,
+		},
+		"RejectOverlappingScreen": {
+			in: `.block buffer 16384-16+1`,
+		},
+		"RejectVariablesExceedingRAM": {
+			in: `
+.block buffer 16384-16
+	@overflow
+`,
+		},
+		"RejectClashWithVariable": {
+			in: `
+.var buffer
+.block buffer 4
+`,
+		},
+	}
+
+	for name, tc := range errTests {
+		t.Run(name, func(t *testing.T) {
+			err := Assemble(strings.NewReader(tc.in), new(strings.Builder))
+			assertError(t, err)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package hack
+
+import (
+	"sort"
+	"strings"
+)
+
+// IDs of the warnings of the assembler. Warnings like unused labels that are also lint rules share
+// their ID.
+const (
+	warnUnusedVariable = "unused-variable"
+	warnUnreachable    = "unreachable-code"
+	warnNotOptimized   = "not-optimized"
+	warnUnusedLabel    = "unused-label"
+	warnSingleUse      = "single-use-symbol"
+)
+
+// warningIDs are the IDs of the warnings of the assembler.
+var warningIDs = []string{warnUnusedVariable, warnUnreachable, warnNotOptimized, warnUnusedLabel, warnSingleUse}
+
+// suppression silences warnings and lint findings of a rule. It is declared using a comment like
+// // hack:ignore RULE_ID which applies to its own line and, if it is on a line of its own, to the next
+// line or // hack:ignore-file RULE_ID which applies to the whole file.
+type suppression struct {
+	Rule string `json:"rule"`
+	Pos  pos    `json:"pos"`
+	File bool   `json:"file,omitempty"`
+	// Trailing is true if the comment follows code on its line.
+	Trailing bool `json:"trailing,omitempty"`
+}
+
+// suppresses returns true if s silences the rule at position p. Positions in macro expansions are
+// silenced by suppressions in the macro body and at any of the call sites.
+func (s suppression) suppresses(rule string, p pos) bool {
+	if s.Rule != rule {
+		return false
+	}
+	for at := &p; at != nil; at = at.Call {
+		if at.File == s.Pos.File && (s.File || at.Line == s.Pos.Line || (!s.Trailing && at.Line == s.Pos.Line+1)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readSuppressions returns the suppressions declared by comments in lines. A comment can list
+// several rule IDs separated by whitespace or commas.
+func readSuppressions(lines []line) ([]suppression, error) {
+	known := make(map[string]bool)
+	for _, id := range warningIDs {
+		known[id] = true
+	}
+	for _, rule := range lintRules {
+		known[rule.ID] = true
+	}
+
+	var suppressions []suppression
+	for _, l := range lines {
+		code, comment := cutComment(l.Text)
+		directive, ids := cutField(strings.TrimSpace(strings.TrimPrefix(comment, "//")))
+		if directive != "hack:ignore" && directive != "hack:ignore-file" {
+			continue
+		}
+		rules := strings.FieldsFunc(ids, isArgSeparator)
+		if len(rules) == 0 {
+			return nil, errorf(l.Pos, "failed to parse %s: expected the ID of the rule to ignore", directive)
+		}
+		for _, rule := range rules {
+			if !known[rule] {
+				return nil, errorf(l.Pos, "failed to parse %s: unknown rule %q", directive, rule)
+			}
+			suppressions = append(suppressions, suppression{
+				Rule:     rule,
+				Pos:      l.Pos,
+				File:     directive == "hack:ignore-file",
+				Trailing: strings.TrimSpace(code) != "",
+			})
+		}
+	}
+	return suppressions, nil
+}
+
+// suppressed returns true if one of the suppressions silences the rule at position p.
+func suppressed(suppressions []suppression, rule string, p pos) bool {
+	for _, s := range suppressions {
+		if s.suppresses(rule, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnSymbols warns about labels that are never referenced and undeclared symbols that are used only
+// once as they are likely typos that turned into variables.
+func (a *Assembler) warnSymbols(instructions []instruction) {
+	p := newLintProgram(instructions, nil)
+	var findings []lintFinding
+	for _, f := range unusedLabels(p) {
+		f.rule = warnUnusedLabel
+		findings = append(findings, f)
+	}
+	for _, f := range singleUseSymbols(p) {
+		f.rule = warnSingleUse
+		findings = append(findings, f)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].index < findings[j].index
+	})
+	for _, f := range findings {
+		if !strings.HasPrefix(f.pos.File, "std/") {
+			a.warnf(f.pos, f.rule, "%s", f.message)
+		}
+	}
+}
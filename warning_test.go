@@ -0,0 +1,182 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want string
+	}{
+		"WarnAboutUnusedLabelsAndSymbolsUsedOnce": {
+			in: `
+(START)
+	@counter
+	M=1
+(END)
+	@END
+	0;JMP
+`,
+			want: "line 2: warning: label \"START\" is never referenced [unused-label]\n" +
+				"line 3: warning: symbol \"counter\" is used only once and allocated as a variable, is it a typo? [single-use-symbol]\n",
+		},
+		"IgnoreOnTheSameLine": {
+			in: `
+(START) // hack:ignore unused-label
+	@counter
+	M=1
+`,
+			want: "line 3: warning: symbol \"counter\" is used only once and allocated as a variable, is it a typo? [single-use-symbol]\n",
+		},
+		"IgnoreOnThePreviousLine": {
+			in: `
+	// hack:ignore single-use-symbol
+	@counter
+	M=1
+`,
+			want: "",
+		},
+		"TrailingIgnoreDoesNotApplyToTheNextLine": {
+			in: `
+(UNUSED) // hack:ignore unused-label
+(TAIL)
+	@R0
+`,
+			want: "line 3: warning: label \"TAIL\" is never referenced [unused-label]\n",
+		},
+		"IgnoreDoesNotApplyToOtherLines": {
+			in: `
+// hack:ignore unused-label
+
+(START)
+`,
+			want: "line 4: warning: label \"START\" is never referenced [unused-label]\n",
+		},
+		"IgnoreOnlyGivenRules": {
+			in: `
+	@counter // hack:ignore unused-label
+	M=1
+`,
+			want: "line 2: warning: symbol \"counter\" is used only once and allocated as a variable, is it a typo? [single-use-symbol]\n",
+		},
+		"IgnoreSeveralRules": {
+			in: `
+// hack:ignore unused-label, unused-variable
+.var unused
+(START)
+`,
+			want: "line 4: warning: label \"START\" is never referenced [unused-label]\n",
+		},
+		"IgnoreFile": {
+			in: `
+// hack:ignore-file unused-label
+(START)
+	@R0
+(NEXT)
+	D=M
+`,
+			want: "",
+		},
+		"IgnoreAtMacroCallSite": {
+			in: `
+.macro inc x
+	@x
+	M=M+1
+.endm
+	inc counter // hack:ignore single-use-symbol
+`,
+			want: "",
+		},
+		"IgnoreInString": {
+			in: `
+.equ SLASHES '/' // hack:ignore unused-label
+	@'/'
+(START)
+`,
+			want: "line 4: warning: label \"START\" is never referenced [unused-label]\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var warnings strings.Builder
+			asm := Assembler{Warnings: &warnings}
+			err := asm.Assemble(strings.NewReader(tc.in), new(strings.Builder))
+			assertNoError(t, err)
+
+			assertDeepEquals(t, "Assemble", tc.in, warnings.String(), tc.want)
+		})
+	}
+
+	t.Run("RejectUnknownRule", func(t *testing.T) {
+		err := Assemble(strings.NewReader("\t@R0 // hack:ignore unused-lable"), new(strings.Builder))
+		assertError(t, err)
+	})
+
+	t.Run("RejectMissingRule", func(t *testing.T) {
+		err := Assemble(strings.NewReader("// hack:ignore-file"), new(strings.Builder))
+		assertError(t, err)
+	})
+}
+
+func TestWarningsAsErrors(t *testing.T) {
+	t.Run("FailOnWarning", func(t *testing.T) {
+		var machine strings.Builder
+		asm := Assembler{WarningsAsErrors: true}
+		err := asm.Assemble(strings.NewReader("(START)\n\t@R0\n\tD=M"), &machine)
+		assertError(t, err)
+
+		assertDeepEquals(t, "Assemble", "", machine.String(), "")
+	})
+
+	t.Run("SucceedIfWarningsAreSuppressed", func(t *testing.T) {
+		var machine strings.Builder
+		asm := Assembler{WarningsAsErrors: true}
+		err := asm.Assemble(strings.NewReader("(START) // hack:ignore unused-label\n\t@R0\n\tD=M"), &machine)
+		assertNoError(t, err)
+
+		assertDeepEquals(t, "Assemble", "", machine.String(), "0000000000000000\n1111110000010000\n")
+	})
+
+	t.Run("SuppressionsAreLinked", func(t *testing.T) {
+		var object bytes.Buffer
+		err := new(Assembler).Compile(strings.NewReader("// hack:ignore-file unused-label\n(START)\n\t@R0"), &object)
+		assertNoError(t, err)
+		o, err := ReadObject(&object, "start.o")
+		assertNoError(t, err)
+
+		asm := Assembler{WarningsAsErrors: true}
+		err = asm.Link([]*Object{o}, new(strings.Builder))
+		assertNoError(t, err)
+	})
+}
+
+func TestLintSuppressions(t *testing.T) {
+	in := `
+	@DONE // hack:ignore jump-to-variable
+	D;JEQ
+(DONE)
+`
+	var got strings.Builder
+	err := new(Linter).Lint(strings.NewReader(in), "", &got)
+	assertError(t, err)
+
+	want := "line 4: error: label \"DONE\" is not followed by any instruction, jumping to it runs past the program [label-at-end]\n"
+	assertDeepEquals(t, "Lint", in, got.String(), want)
+
+	trailingIn := "(UNUSED) // hack:ignore unused-label\n(TAIL)\n\t@R0"
+	var trailing strings.Builder
+	err = new(Linter).Lint(strings.NewReader(trailingIn), "", &trailing)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Lint", trailingIn, trailing.String(), "line 2: warning: label \"TAIL\" is never referenced [unused-label]\n")
+
+	var suppressed strings.Builder
+	err = new(Linter).Lint(strings.NewReader("// hack:ignore-file label-at-end\n"+in), "", &suppressed)
+	assertNoError(t, err)
+
+	assertDeepEquals(t, "Lint", in, suppressed.String(), "")
+}